@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic replaces the file at path by one with content b and permissions perm.
+// The content is written to a temporary file in the same directory first which is then renamed,
+// hence readers never see a partially written file.
+func writeFileAtomic(path string, b []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+
+	if err := writeTempFile(f, b, perm); err != nil {
+		return errors.Join(err, os.Remove(f.Name()))
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return errors.Join(
+			fmt.Errorf("failed to rename temporary file: %w", err),
+			os.Remove(f.Name()),
+		)
+	}
+
+	return nil
+}
+
+func writeTempFile(f *os.File, b []byte, perm os.FileMode) error {
+	if _, err := f.Write(b); err != nil {
+		return errors.Join(
+			fmt.Errorf("failed to write temporary file: %w", err),
+			f.Close(),
+		)
+	}
+
+	// os.CreateTemp creates files only readable by their owner
+	if err := f.Chmod(perm); err != nil {
+		return errors.Join(
+			fmt.Errorf("failed to chmod temporary file: %w", err),
+			f.Close(),
+		)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+
+	return nil
+}
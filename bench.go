@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const cmdBench = "bench"
+
+// defaultBenchSize is the default number of TLDs in the source generated by the bench command.
+const defaultBenchSize = 100_000
+
+var errInvalidBenchSize = errors.New("bench size must be positive")
+
+// benchStage is the timing of a single stage of the bench command.
+type benchStage struct {
+	Name     string        `json:"name"`
+	TLDs     int           `json:"tlds"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// perSecond returns the throughput of the stage in TLDs per second.
+func (s benchStage) perSecond() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.TLDs) / s.Duration.Seconds()
+}
+
+// bench parses and stores a generated source of size TLDs using in-memory databases and reports how long each stage took.
+// It exercises the same code paths as a regular run, with cfg's parse options applied.
+func bench(ctx context.Context, l *slog.Logger, w io.Writer, cfg config, size int) error {
+	if size <= 0 {
+		return fmt.Errorf("%w: got %d", errInvalidBenchSize, size)
+	}
+
+	src := benchSource(size)
+	var stages []benchStage
+
+	start := time.Now()
+	tlds, _, err := parseTLDs(ctx, l, bytes.NewReader(src), cfg.parse)
+	if err != nil {
+		return err
+	}
+	stages = append(stages, benchStage{Name: "parse", TLDs: len(tlds), Duration: time.Since(start)})
+
+	db, err := openMemoryDB()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			l.ErrorContext(ctx, fmt.Errorf("failed to close database: %w", err).Error())
+		}
+	}()
+
+	opts := storeOptions{ordinals: true}
+	start = time.Now()
+	if _, err := syncWithDB(ctx, l, db, tlds, opts); err != nil {
+		return err
+	}
+	stages = append(stages, benchStage{Name: "insert", TLDs: len(tlds), Duration: time.Since(start)})
+
+	// All TLDs are stored already, hence this only measures detecting duplicates
+	start = time.Now()
+	if _, err := syncWithDB(ctx, l, db, tlds, opts); err != nil {
+		return err
+	}
+	stages = append(stages, benchStage{Name: "resync", TLDs: len(tlds), Duration: time.Since(start)})
+
+	st, err := benchStream(ctx, l, cfg, src)
+	if err != nil {
+		return err
+	}
+	stages = append(stages, st)
+
+	if err := writeBench(w, cfg.format, stages); err != nil {
+		return fmt.Errorf("failed to print bench result: %w", err)
+	}
+
+	return nil
+}
+
+// benchStream parses and stores src in batches like -stream does.
+// The source is written to a temporary file first as streaming reads from cfg.source.
+func benchStream(ctx context.Context, l *slog.Logger, cfg config, src []byte) (benchStage, error) {
+	f, err := os.CreateTemp("", "tldwatch-bench-*.txt")
+	if err != nil {
+		return benchStage{}, fmt.Errorf("failed to create bench source: %w", err)
+	}
+	defer func() {
+		if err := os.Remove(f.Name()); err != nil {
+			l.ErrorContext(ctx, fmt.Errorf("failed to remove bench source: %w", err).Error())
+		}
+	}()
+	if _, err := f.Write(src); err != nil {
+		return benchStage{}, errors.Join(fmt.Errorf("failed to write bench source: %w", err), f.Close())
+	}
+	if err := f.Close(); err != nil {
+		return benchStage{}, fmt.Errorf("failed to close bench source: %w", err)
+	}
+
+	db, err := openMemoryDB()
+	if err != nil {
+		return benchStage{}, err
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			l.ErrorContext(ctx, fmt.Errorf("failed to close database: %w", err).Error())
+		}
+	}()
+
+	cfg.source = f.Name()
+	start := time.Now()
+	res, err := streamWithDB(ctx, l, db, cfg)
+	if err != nil {
+		return benchStage{}, err
+	}
+
+	return benchStage{Name: "stream", TLDs: res.total, Duration: time.Since(start)}, nil
+}
+
+// benchSource generates a source in the format of the IANA list holding size distinct TLDs.
+func benchSource(size int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by tldwatch bench\n")
+	for i := range size {
+		buf.WriteString("BENCH")
+		buf.WriteString(strconv.Itoa(i))
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// openMemoryDB opens a private in-memory database.
+func openMemoryDB() (*sql.DB, error) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open in-memory database: %w", err)
+	}
+	// Every connection to ":memory:" gets its own database
+	db.SetMaxOpenConns(1)
+
+	return db, nil
+}
+
+func writeBench(w io.Writer, format string, stages []benchStage) error {
+	switch format {
+	case formatJSON, formatJSONObject:
+		return writeJSON(w, stages)
+	case formatHuman:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		for _, s := range stages {
+			if _, err := fmt.Fprintf(tw, "%s\t%d TLDs\t%s\t%.0f TLDs/s\t\n", s.Name, s.TLDs, s.Duration.Round(time.Microsecond), s.perSecond()); err != nil {
+				return fmt.Errorf("failed to write: %w", err)
+			}
+		}
+		if err := tw.Flush(); err != nil {
+			return fmt.Errorf("failed to flush: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", errUnknownFormat, format)
+	}
+}
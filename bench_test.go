@@ -0,0 +1,30 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestBench(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestConfig("", "")
+	var buf bytes.Buffer
+	if err := bench(t.Context(), newTestLogger(), &buf, cfg, 100); err != nil {
+		t.Fatalf("bench failed: %v", err)
+	}
+
+	var stages []benchStage
+	if err := json.Unmarshal(buf.Bytes(), &stages); err != nil {
+		t.Fatalf("failed to decode output %q: %v", buf.String(), err)
+	}
+	if got, want := len(stages), 4; got != want {
+		t.Fatalf("got %d stages, want %d", got, want)
+	}
+	for _, s := range stages {
+		if s.TLDs != 100 {
+			t.Errorf("stage %q processed %d TLDs, want 100", s.Name, s.TLDs)
+		}
+	}
+}
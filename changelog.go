@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const changelogFilePerm = 0o644
+
+// appendChangelog appends one "<timestamp> +<tld>" line per added and one "<timestamp> -<tld>" line per removed TLD
+// to the changelog file at path.
+// The timestamp is rendered in loc, UTC if nil.
+// All lines are written with a single write call to an O_APPEND file so that concurrent writers don't interleave.
+func appendChangelog(path string, at time.Time, loc *time.Location, added, removed []tld) error {
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	if loc == nil {
+		loc = time.UTC
+	}
+	ts := at.In(loc).Format(time.RFC3339)
+
+	var b strings.Builder
+	writeLine := func(sign string, t tld) {
+		b.WriteString(ts)
+		b.WriteString(" " + sign)
+		b.WriteString(string(t))
+		b.WriteByte('\n')
+	}
+	for _, t := range added {
+		writeLine("+", t)
+	}
+	for _, t := range removed {
+		writeLine("-", t)
+	}
+
+	//nolint:gosec // The changelog is meant to be readable by others
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, changelogFilePerm)
+	if err != nil {
+		return fmt.Errorf("failed to open changelog file: %w", err)
+	}
+
+	if _, err := f.WriteString(b.String()); err != nil {
+		return errors.Join(
+			fmt.Errorf("failed to append to changelog file: %w", err),
+			f.Close(),
+		)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close changelog file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppendChangelogTimezone(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "changelog")
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	if err := appendChangelog(path, at, nil, []tld{"com"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := appendChangelog(path, at, time.FixedZone("", 2*60*60), []tld{"net"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path) //nolint:gosec // The path is a temporary file
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "2024-01-02T03:04:05Z +com\n2024-01-02T05:04:05+02:00 +net\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestRunChangelog(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cfg := newTestConfig(filepath.Join(dir, "tlds.txt"), filepath.Join(dir, "db.sqlite"))
+	cfg.changelogFile = filepath.Join(dir, "changelog")
+
+	for _, src := range []string{"COM\nNET\n", "COM\nORG\n", "COM\nORG\n"} {
+		if err := os.WriteFile(cfg.source, []byte(src), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		runToString(t, cfg)
+	}
+
+	b, err := os.ReadFile(cfg.changelogFile) //nolint:gosec // The path is a temporary file
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, 0)
+	for _, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+		_, change, _ := strings.Cut(line, " ")
+		got = append(got, change)
+	}
+	// The last run didn't change anything
+	if want := []string{"+com", "+net", "+org", "-net"}; !slices.Equal(got, want) {
+		t.Fatalf("got changes %q, want %q", got, want)
+	}
+}
+
+func TestRunChangelogUnwritable(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cfg := newTestConfig(fixturePath, filepath.Join(dir, "db.sqlite"))
+	cfg.changelogFile = filepath.Join(dir, "missing", "changelog")
+
+	var buf bytes.Buffer
+	if err := run(t.Context(), newTestLogger(), &buf, cfg); err == nil {
+		t.Fatal("got no error appending to the changelog in a missing directory")
+	}
+	// The outputs are written before the changelog
+	if !strings.Contains(buf.String(), `"com"`) {
+		t.Fatalf("got output %q, want it to list the added TLDs", buf.String())
+	}
+}
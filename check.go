@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+const cmdCheckAll = "check-all"
+
+var errNotInitialized = errors.New("database is not initialized, run tldwatch first")
+
+// checkResult is the outcome of checking a single domain.
+type checkResult struct {
+	Domain string `json:"domain"`
+	TLD    tld    `json:"tld"`
+	Valid  bool   `json:"valid"`
+	// Registrable is the matched TLD along with the label preceding it, if any
+	Registrable string `json:"registrable_domain,omitempty"`
+}
+
+// checkAll reads domains from r, one per line, and reports for each whether its TLD is a known one.
+// Input is processed line by line, hence arbitrarily large inputs can be checked.
+// The database is opened read-only.
+func checkAll(
+	ctx context.Context,
+	l *slog.Logger,
+	r io.Reader,
+	w io.Writer,
+	cfg config,
+) error {
+	if _, err := os.Stat(cfg.sqliteFile); errors.Is(err, os.ErrNotExist) {
+		return errNotInitialized
+	}
+
+	db, err := openDB(ctx, l, readOnlyDSN(cfg.sqliteFile), cfg.dbOpenRetries, cfg.dbOpenRetryDelay)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			l.ErrorContext(ctx, fmt.Errorf("failed to close database: %w", err).Error())
+		}
+	}()
+
+	var hasSchema bool
+	if err := db.QueryRowContext(ctx, sqliteHasSchemaStmt).Scan(&hasSchema); err != nil {
+		return fmt.Errorf("failed to check for database schema: %w", err)
+	}
+	if !hasSchema {
+		return errNotInitialized
+	}
+
+	stmt, err := liveStmt(ctx, db, sqliteLiveSelectStmt, sqliteSelectStmt)
+	if err != nil {
+		return err
+	}
+	stored, err := queryTLDs(ctx, db, stmt)
+	if err != nil {
+		return err
+	}
+	known := make(map[tld]struct{}, len(stored))
+	for _, t := range stored {
+		known[t] = struct{}{}
+	}
+
+	toStored := cfg.parse.storedForm(idna.New(idna.BidiRule()))
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		domain := strings.TrimSpace(scanner.Text())
+		if domain == "" {
+			continue
+		}
+
+		res := checkResult{
+			Domain: domain,
+			TLD:    domainTLD(toStored, domain),
+		}
+		if suffix, registrable, ok := matchSuffix(toStored, known, domain); ok {
+			res.TLD, res.Registrable, res.Valid = suffix, registrable, true
+		}
+
+		if cfg.onlyInvalid && res.Valid {
+			continue
+		}
+		if err := writeCheckResult(w, cfg.format, res); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to scan input: %w", err)
+	}
+
+	return nil
+}
+
+// domainTLD extracts the TLD of domain in the form it is stored in.
+func domainTLD(toStored func(string) (string, error), domain string) tld {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	label := domain[strings.LastIndexByte(domain, '.')+1:]
+
+	t, err := toStored(label)
+	if err != nil {
+		return tld(label)
+	}
+
+	return tld(t)
+}
+
+// matchSuffix looks up the longest suffix of domain which is stored in known.
+// It returns the suffix in the form it is stored in and the registrable domain, i.e. the suffix preceded by one more label of domain.
+// The registrable domain is empty if domain consists of the suffix only.
+func matchSuffix(toStored func(string) (string, error), known map[tld]struct{}, domain string) (tld, string, bool) {
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(domain, ".")), ".")
+	for i := range labels {
+		// Stored TLDs are in Unicode form unless -no-idna is set, hence convert label by label
+		suffix := make([]string, 0, len(labels)-i)
+		for _, label := range labels[i:] {
+			if u, err := toStored(label); err == nil {
+				label = u
+			}
+			suffix = append(suffix, label)
+		}
+
+		t := tld(strings.Join(suffix, "."))
+		if _, ok := known[t]; !ok {
+			continue
+		}
+		if i == 0 {
+			return t, "", true
+		}
+		return t, strings.Join(labels[i-1:], "."), true
+	}
+
+	return "", "", false
+}
+
+func writeCheckResult(w io.Writer, format string, res checkResult) error {
+	switch format {
+	case formatJSON, formatJSONObject:
+		return writeJSON(w, res)
+	case formatHuman:
+		status := "valid"
+		if !res.Valid {
+			status = "invalid"
+		}
+		line := status + "\t" + res.Domain
+		if res.Registrable != "" {
+			line += "\t" + res.Registrable
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return fmt.Errorf("failed to write: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", errUnknownFormat, format)
+	}
+}
@@ -0,0 +1,97 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/idna"
+)
+
+func TestCheckAll(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestConfig(fixturePath, filepath.Join(t.TempDir(), "db.sqlite"))
+	runToString(t, cfg)
+
+	const input = "example.com\nwww.example.CO.UK.\n\nexample.invalid\nпример.рф\nexample.xn--p1ai\n"
+
+	for _, tc := range []struct {
+		name        string
+		format      string
+		onlyInvalid bool
+		want        string
+	}{
+		{
+			"human", formatHuman, false,
+			"valid\texample.com\texample.com\nvalid\twww.example.CO.UK.\tco.uk\ninvalid\texample.invalid\nvalid\tпример.рф\tпример.рф\nvalid\texample.xn--p1ai\texample.xn--p1ai\n",
+		},
+		{
+			"only-invalid", formatHuman, true,
+			"invalid\texample.invalid\n",
+		},
+		{
+			"json", formatJSON, true,
+			`{"domain":"example.invalid","tld":"invalid","valid":false}` + "\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := cfg
+			cfg.format = tc.format
+			cfg.onlyInvalid = tc.onlyInvalid
+
+			var buf bytes.Buffer
+			if err := checkAll(t.Context(), newTestLogger(), strings.NewReader(input), &buf, cfg); err != nil {
+				t.Fatalf("failed to check: %v", err)
+			}
+			if got := buf.String(); got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCheckAllNotInitialized(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestConfig(fixturePath, filepath.Join(t.TempDir(), "db.sqlite"))
+
+	var buf bytes.Buffer
+	err := checkAll(t.Context(), newTestLogger(), strings.NewReader("example.com\n"), &buf, cfg)
+	if !errors.Is(err, errNotInitialized) {
+		t.Fatalf("got error %v, want %v", err, errNotInitialized)
+	}
+	if _, err := os.Stat(cfg.sqliteFile); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("database was created: %v", err)
+	}
+}
+
+func TestMatchSuffix(t *testing.T) {
+	t.Parallel()
+
+	known := map[tld]struct{}{"uk": {}, "co.uk": {}, "рф": {}}
+	prof := idna.New(idna.BidiRule())
+
+	for _, tc := range []struct {
+		domain      string
+		suffix      tld
+		registrable string
+		ok          bool
+	}{
+		{"www.example.co.uk", "co.uk", "example.co.uk", true},
+		{"example.uk.", "uk", "example.uk", true},
+		{"CO.UK", "co.uk", "", true},
+		{"www.xn--e1afmkfd.xn--p1ai", "рф", "xn--e1afmkfd.xn--p1ai", true},
+		{"example.invalid", "", "", false},
+	} {
+		suffix, registrable, ok := matchSuffix(prof.ToUnicode, known, tc.domain)
+		if suffix != tc.suffix || registrable != tc.registrable || ok != tc.ok {
+			t.Errorf("%s: got (%q, %q, %t), want (%q, %q, %t)", tc.domain, suffix, registrable, ok, tc.suffix, tc.registrable, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" //nolint:gosec // MD5 is only used to verify checksum files which are published in that form
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxChecksumFileSize limits the size of checksum files, which hold a single digest and possibly a file name.
+const maxChecksumFileSize = 4 * 1024
+
+var (
+	errNoChecksum       = errors.New("no checksum file found")
+	errInvalidChecksum  = errors.New("invalid checksum file")
+	errChecksumMismatch = errors.New("source doesn't match its checksum")
+	errChecksumStream   = errors.New("verifying the checksum requires reading the source in full, which defeats streaming")
+)
+
+// checksumKind is a form of checksum file, named after the source with ext appended.
+type checksumKind struct {
+	ext     string
+	newHash func() hash.Hash
+}
+
+// checksumKinds are tried in order when discovering the checksum file of a source.
+//
+//nolint:gochecknoglobals // Slices can't be constants
+var checksumKinds = []checksumKind{
+	{ext: ".sha256", newHash: sha256.New},
+	{ext: ".md5", newHash: md5.New},
+}
+
+// checksum is the expected digest of a source.
+type checksum struct {
+	url    string
+	kind   checksumKind
+	digest []byte
+}
+
+// fetchChecksum discovers the checksum file of sourceURL by trying the extensions of checksumKinds in order.
+// Checksum files either hold the hex-encoded digest only or are in the format of sha256sum and md5sum.
+func fetchChecksum(
+	ctx context.Context,
+	requestTimeout time.Duration,
+	l *slog.Logger,
+	sourceURL string,
+	fetchOpts fetchOptions,
+) (checksum, error) {
+	client := newHTTPClient(requestTimeout, fetchOpts)
+
+	for _, kind := range checksumKinds {
+		u := sourceURL + kind.ext
+		b, err := getChecksumFile(ctx, client, u, fetchOpts)
+		if errors.Is(err, errNoChecksum) {
+			l.DebugContext(
+				ctx,
+				"no checksum file",
+				"url", redactURL(u),
+			)
+			continue
+		}
+		if err != nil {
+			return checksum{}, err
+		}
+
+		fields := strings.Fields(string(b))
+		if len(fields) == 0 {
+			return checksum{}, fmt.Errorf("%w: %q is empty", errInvalidChecksum, redactURL(u))
+		}
+		digest, err := hex.DecodeString(fields[0])
+		if err != nil || len(digest) != kind.newHash().Size() {
+			return checksum{}, fmt.Errorf("%w: %q doesn't start with a %s digest", errInvalidChecksum, redactURL(u), strings.TrimPrefix(kind.ext, "."))
+		}
+
+		return checksum{url: u, kind: kind, digest: digest}, nil
+	}
+
+	return checksum{}, fmt.Errorf("%w next to %q", errNoChecksum, redactURL(sourceURL))
+}
+
+// getChecksumFile returns the content of the checksum file at u, failing with errNoChecksum if it doesn't exist.
+func getChecksumFile(ctx context.Context, client *http.Client, u string, fetchOpts fetchOptions) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, vs := range fetchOpts.header {
+		// The checksum file isn't in any of the source formats
+		if http.CanonicalHeaderKey(k) == "Accept" {
+			continue
+		}
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get checksum file: %w", err)
+	}
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, errors.Join(errNoChecksum, res.Body.Close())
+	case res.StatusCode != http.StatusOK:
+		return nil, errors.Join(fmt.Errorf("%w: %s for %q", errUnexpectedStatus, res.Status, redactURL(u)), res.Body.Close())
+	}
+
+	b, err := io.ReadAll(io.LimitReader(res.Body, maxChecksumFileSize))
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to read checksum file: %w", err), res.Body.Close())
+	}
+	if err := res.Body.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close checksum file: %w", err)
+	}
+
+	return b, nil
+}
+
+// verifyChecksum reads r in full and returns a reader of its content if it matches c.
+// Reading it in full makes sure that nothing is parsed, let alone stored, before the source is verified,
+// hence it's refused when streaming, see streamTLDs.
+func verifyChecksum(r io.Reader, c checksum) (io.Reader, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source: %w", err)
+	}
+
+	h := c.kind.newHash()
+	h.Write(b)
+	if got := h.Sum(nil); !bytes.Equal(got, c.digest) {
+		return nil, fmt.Errorf(
+			"%w %q: got %s digest %x, want %x",
+			errChecksumMismatch, c.url, strings.TrimPrefix(c.kind.ext, "."), got, c.digest,
+		)
+	}
+
+	return bytes.NewReader(b), nil
+}
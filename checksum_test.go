@@ -0,0 +1,83 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"bufio"
+	"crypto/md5" //nolint:gosec // The checksum files under test are MD5 ones
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestVerifyChecksum(t *testing.T) {
+	t.Parallel()
+
+	src, err := os.ReadFile(fixturePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md5Sum := md5.Sum(src) //nolint:gosec // See above
+	sha256Sum := sha256.Sum256(src)
+
+	for _, tc := range []struct {
+		name    string
+		files   map[string]string
+		wantErr error
+	}{
+		{
+			name:  "sha256",
+			files: map[string]string{"/tlds.txt.sha256": hex.EncodeToString(sha256Sum[:]) + "\n"},
+		},
+		{
+			name:  "md5sum format",
+			files: map[string]string{"/tlds.txt.md5": hex.EncodeToString(md5Sum[:]) + "  tlds.txt\n"},
+		},
+		{
+			name:    "mismatch",
+			files:   map[string]string{"/tlds.txt.md5": "00000000000000000000000000000000\n"},
+			wantErr: errChecksumMismatch,
+		},
+		{
+			name:    "invalid",
+			files:   map[string]string{"/tlds.txt.sha256": hex.EncodeToString(md5Sum[:]) + "\n"},
+			wantErr: errInvalidChecksum,
+		},
+		{
+			name:    "missing",
+			wantErr: errNoChecksum,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/tlds.txt" {
+					http.ServeFile(w, r, fixturePath)
+					return
+				}
+				content, ok := tc.files[r.URL.Path]
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				if _, err := w.Write([]byte(content)); err != nil {
+					t.Errorf("failed to write: %v", err)
+				}
+			}))
+			t.Cleanup(srv.Close)
+
+			tlds, _, err := loadTLDs(t.Context(), requestTimeout, newTestLogger(), srv.URL+"/tlds.txt", fetchOptions{
+				verifyChecksum: true,
+			}, parseOptions{maxLineSize: bufio.MaxScanTokenSize})
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tc.wantErr)
+			}
+			if tc.wantErr == nil && len(tlds) != 42 {
+				t.Fatalf("got %d TLDs, want 42", len(tlds))
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+)
+
+const cmdCompare = "compare"
+
+var (
+	errCompareArgs   = errors.New("compare needs one or two sources")
+	errSourcesDiffer = errors.New("sources differ")
+)
+
+// compare loads the TLDs from sources, which is either a single source to compare cfg.source against or two sources to compare with each other.
+// TLDs only listed by the second source are reported as added, those only listed by the first one as removed.
+// The database is not touched.
+func compare(
+	ctx context.Context,
+	l *slog.Logger,
+	w io.Writer,
+	cfg config,
+	sources []string,
+) error {
+	switch len(sources) {
+	case 1:
+		sources = []string{cfg.source, sources[0]}
+	case 2:
+	default:
+		return fmt.Errorf("%w, got %d", errCompareArgs, len(sources))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	prev, prevDisplay, err := loadTLDs(ctx, requestTimeout, l, sources[0], cfg.fetch, cfg.parse)
+	if err != nil {
+		return fmt.Errorf("failed to load %q: %w", sources[0], err)
+	}
+	next, nextDisplay, err := loadTLDs(ctx, requestTimeout, l, sources[1], cfg.fetch, cfg.parse)
+	if err != nil {
+		return fmt.Errorf("failed to load %q: %w", sources[1], err)
+	}
+
+	d := diffTLDs(prev, next)
+	res := result{
+		RunLabel:  cfg.runLabel,
+		Added:     d.added,
+		Removed:   d.removed,
+		outputKey: cfg.outputKey,
+		template:  cfg.template,
+	}
+	res.extractChanged()
+	if cfg.parse.preserveCase {
+		res.display = maps.Clone(prevDisplay)
+		maps.Copy(res.display, nextDisplay)
+	}
+
+	l.InfoContext(
+		ctx,
+		"compared sources",
+		"first", sources[0],
+		"second", sources[1],
+		"only_first", len(d.removed),
+		"only_second", len(d.added),
+	)
+
+	if err := writeOutputs(w, cfg.outputTargets(), cfg.format, res); err != nil {
+		return fmt.Errorf("failed to print result: %w", err)
+	}
+
+	if len(d.added) > 0 || len(d.removed) > 0 {
+		return errSourcesDiffer
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	t.Parallel()
+
+	srv := newFixtureServer(t)
+
+	mirror := filepath.Join(t.TempDir(), "mirror.txt")
+	if err := os.WriteFile(mirror, []byte("# Version 1\nCOM\nNEW\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := newTestConfig(srv.URL, filepath.Join(t.TempDir(), "db.sqlite"))
+	cfg.format = formatJSONObject
+
+	var buf bytes.Buffer
+	if err := compare(t.Context(), newTestLogger(), &buf, cfg, []string{fixturePath}); err != nil {
+		t.Fatalf("got error %v comparing identical sources", err)
+	}
+
+	buf.Reset()
+	if err := compare(t.Context(), newTestLogger(), &buf, cfg, []string{fixturePath, mirror}); !errors.Is(err, errSourcesDiffer) {
+		t.Fatalf("got error %v, want %v", err, errSourcesDiffer)
+	}
+
+	var res result
+	if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
+		t.Fatalf("failed to decode output %q: %v", buf.String(), err)
+	}
+	if !slices.Equal(res.Added, []tld{"new"}) {
+		t.Errorf("got added %v, want [new]", res.Added)
+	}
+	if got, want := len(res.Removed), 41; got != want {
+		t.Errorf("got %d removed TLDs, want %d", got, want)
+	}
+
+	if _, err := os.Stat(cfg.sqliteFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("compare touched the database: %v", err)
+	}
+
+	if err := compare(t.Context(), newTestLogger(), &buf, cfg, nil); !errors.Is(err, errCompareArgs) {
+		t.Errorf("got error %v, want %v", err, errCompareArgs)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+const (
+	inputFormatAuto = "auto"
+	inputFormatText = "text"
+	inputFormatCSV  = "csv"
+
+	defaultCSVColumn = "tld"
+)
+
+var (
+	errUnknownInputFormat = errors.New("unknown input format")
+	errCSVColumnNotFound  = errors.New("CSV column not found")
+	errDuplicateFormat    = errors.New("input format listed twice")
+)
+
+func validateInputFormat(format string) error {
+	switch format {
+	case inputFormatAuto, inputFormatText, inputFormatCSV:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", errUnknownInputFormat, format)
+	}
+}
+
+// acceptHeader returns the value of an Accept header asking for the input formats in order of preference.
+// The media types are weighted by decreasing quality values, e.g. "text/plain, text/csv;q=0.9".
+func acceptHeader(formats []string) (string, error) {
+	ranges := make([]string, 0, len(formats))
+	seen := make(map[string]struct{}, len(formats))
+	for i, f := range formats {
+		f = strings.TrimSpace(f)
+		if _, ok := seen[f]; ok {
+			return "", fmt.Errorf("%w: %q", errDuplicateFormat, f)
+		}
+		seen[f] = struct{}{}
+
+		var mediaType string
+		switch f {
+		case inputFormatText:
+			mediaType = "text/plain"
+		case inputFormatCSV:
+			mediaType = "text/csv"
+		default:
+			return "", fmt.Errorf("%w: %q", errUnknownInputFormat, f)
+		}
+		if i > 0 {
+			//nolint:mnd // Quality values are given in tenths
+			mediaType += ";q=" + strconv.FormatFloat(1-float64(i)/10, 'f', 1, 64)
+		}
+		ranges = append(ranges, mediaType)
+	}
+
+	return strings.Join(ranges, ", "), nil
+}
+
+// inputFormatFor resolves the input format of source.
+// With the auto format, CSV is detected by the response's Content-Type or the file extension,
+// everything else is treated as the plain-text IANA list.
+func inputFormatFor(format, source, contentType string) string {
+	if format != inputFormatAuto {
+		return format
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && mediaType == "text/csv" {
+		return inputFormatCSV
+	}
+
+	lower := strings.ToLower(source)
+	if strings.HasSuffix(lower, ".csv") || strings.HasSuffix(lower, ".csv.gz") {
+		return inputFormatCSV
+	}
+
+	return inputFormatText
+}
+
+// decodeCSV extracts the TLD column from CSV data into the plain-text list format.
+// column is either the (case-insensitive) name of a header column or a zero-based column index.
+// In the latter case, the first record is not treated as a header.
+func decodeCSV(r io.Reader, column string) (io.Reader, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+
+	idx, err := strconv.Atoi(column)
+	if err != nil {
+		header, err := cr.Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV header: %w", err)
+		}
+
+		idx = -1
+		for i, name := range header {
+			if strings.EqualFold(strings.TrimSpace(name), column) {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return nil, fmt.Errorf("%w: %q", errCSVColumnNotFound, column)
+		}
+	}
+
+	var b strings.Builder
+	for {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %w", err)
+		}
+		if idx >= len(rec) {
+			continue
+		}
+
+		// TLDs are commonly listed with their leading dot
+		b.WriteString(strings.TrimPrefix(strings.TrimSpace(rec[idx]), "."))
+		b.WriteByte('\n')
+	}
+
+	return strings.NewReader(b.String()), nil
+}
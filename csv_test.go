@@ -0,0 +1,92 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"bufio"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDecodeCSV(t *testing.T) {
+	t.Parallel()
+
+	const src = `Domain,Registry Operator,Date of Contract Signature
+.aaa,American Automobile Association,2015-02-26
+.photography,"Binky Moon, LLC",2013-11-21
+.xn--p1ai,"Coordination Center for TLD RU",
+`
+
+	for _, tc := range []struct {
+		name    string
+		src     string
+		column  string
+		want    []tld
+		wantErr error
+	}{
+		{"header", src, "domain", []tld{"aaa", "photography", "рф"}, nil},
+		{"index", "AAA,1\nCOM,2\n", "0", []tld{"aaa", "com"}, nil},
+		{"missing-column", src, "tld", nil, errCSVColumnNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r, err := decodeCSV(strings.NewReader(tc.src), tc.column)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			tlds, _, err := parseTLDs(t.Context(), newTestLogger(), r, parseOptions{
+				maxLineSize: bufio.MaxScanTokenSize,
+			})
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+			if !slices.Equal(tlds, tc.want) {
+				t.Fatalf("got TLDs %v, want %v", tlds, tc.want)
+			}
+		})
+	}
+}
+
+func TestInputFormatFor(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		format, source, contentType string
+		want                        string
+	}{
+		{inputFormatAuto, tldURL, "text/plain; charset=UTF-8", inputFormatText},
+		{inputFormatAuto, "https://example.com/tlds", "text/csv; charset=utf-8", inputFormatCSV},
+		{inputFormatAuto, "./gtlds.CSV", "", inputFormatCSV},
+		{inputFormatAuto, "./gtlds.csv.gz", "", inputFormatCSV},
+		{inputFormatText, "./gtlds.csv", "", inputFormatText},
+		{inputFormatCSV, "./tlds.txt", "", inputFormatCSV},
+	} {
+		if got := inputFormatFor(tc.format, tc.source, tc.contentType); got != tc.want {
+			t.Errorf("inputFormatFor(%q, %q, %q) = %q, want %q", tc.format, tc.source, tc.contentType, got, tc.want)
+		}
+	}
+}
+
+func TestAcceptHeader(t *testing.T) {
+	t.Parallel()
+
+	got, err := acceptHeader([]string{"csv", " text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "text/csv, text/plain;q=0.9"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := acceptHeader([]string{"json"}); !errors.Is(err, errUnknownInputFormat) {
+		t.Errorf("got error %v, want %v", err, errUnknownInputFormat)
+	}
+	if _, err := acceptHeader([]string{"text", "text"}); !errors.Is(err, errDuplicateFormat) {
+		t.Errorf("got error %v, want %v", err, errDuplicateFormat)
+	}
+}
@@ -0,0 +1,527 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Policies for TLDs which are stored already.
+const (
+	// conflictIgnore keeps the stored row as-is
+	conflictIgnore = "ignore"
+	// conflictReplace overwrites every column of the stored row but the source which supplied it first
+	conflictReplace = "replace"
+	// conflictUpdate updates the mutable columns of the stored row
+	conflictUpdate = "update"
+)
+
+var (
+	errAlreadyExists         = errors.New("TLD already exists")
+	errImplausibleSource     = errors.New("source lists implausibly few TLDs, refusing to drop the stored ones")
+	errMissingDBDir          = errors.New("database directory doesn't exist")
+	errUnknownConflictPolicy = errors.New("unknown conflict policy")
+)
+
+// checkPlausible makes sure a source listing n TLDs may replace stored ones,
+// i.e. that it isn't empty and lists at least half as many TLDs as stored.
+// Anything less rather points at a broken source than at a real change of the list.
+func checkPlausible(stored, n int) error {
+	if n == 0 || n*2 < stored {
+		return fmt.Errorf("%w: got %d, %d are stored", errImplausibleSource, n, stored)
+	}
+
+	return nil
+}
+
+// ensureDBDir makes sure the directory holding sqliteFile exists, creating it with perm if create is set.
+// Without it, opening the database fails with an obscure "out of memory" error.
+func ensureDBDir(sqliteFile string, create bool, perm os.FileMode) error {
+	dir := filepath.Dir(sqliteFile)
+	if create {
+		if err := os.MkdirAll(dir, perm); err != nil {
+			return fmt.Errorf("failed to create database directory: %w", err)
+		}
+		return nil
+	}
+
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %q, create it or pass -mkdir", errMissingDBDir, dir)
+	} else if err != nil {
+		return fmt.Errorf("failed to stat database directory: %w", err)
+	}
+
+	return nil
+}
+
+// openDB opens the database and makes sure it can actually be reached.
+// sql.Open is lazy, hence we ping the database, retrying up to retries times.
+func openDB(
+	ctx context.Context,
+	l *slog.Logger,
+	sqliteFile string,
+	retries int,
+	retryDelay time.Duration,
+) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", sqliteFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		err = db.PingContext(ctx)
+		if err == nil {
+			return db, nil
+		}
+		if attempt >= retries {
+			break
+		}
+
+		l.WarnContext(
+			ctx,
+			"failed to reach database, retrying",
+			"err", err,
+			"attempt", attempt+1,
+			"delay", retryDelay,
+		)
+		if err := sleepContext(ctx, retryDelay); err != nil {
+			break
+		}
+	}
+
+	if cerr := db.Close(); cerr != nil {
+		l.ErrorContext(ctx, fmt.Errorf("failed to close database: %w", cerr).Error())
+	}
+
+	return nil, fmt.Errorf("failed to reach database: %w", err)
+}
+
+// storeOptions configures how TLDs are stored.
+type storeOptions struct {
+	// prog, if set, is updated while storing
+	prog *progress
+
+	// ordinals records the 1-based position of each TLD in the source
+	ordinals bool
+
+	// source is recorded as the source which supplied a TLD first, NULL if empty
+	source string
+
+	// conflict is the policy for TLDs which are stored already, conflictIgnore if empty
+	conflict string
+
+	// failFast aborts storing on the first unexpected insert error instead of logging it and carrying on
+	failFast bool
+
+	// parse holds the kind overrides and filters the TLDs were parsed with
+	parse parseOptions
+}
+
+func validateConflictPolicy(policy string) error {
+	switch policy {
+	case conflictIgnore, conflictReplace, conflictUpdate:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", errUnknownConflictPolicy, policy)
+	}
+}
+
+// insertStmt returns the statement inserting a TLD according to the conflict policy.
+// Only the one of conflictIgnore fails for TLDs which are stored already, see insertTLD.
+func (o storeOptions) insertStmt() string {
+	switch o.conflict {
+	case conflictReplace:
+		return sqliteReplaceStmt
+	case conflictUpdate:
+		return sqliteUpsertStmt
+	default:
+		return sqliteInsertStmt
+	}
+}
+
+// ordinal returns the ordinal to store for the TLD at index i, NULL unless ordinals are recorded.
+func (o storeOptions) ordinal(i int) sql.NullInt64 {
+	return sql.NullInt64{
+		Int64: int64(i) + 1,
+		Valid: o.ordinals,
+	}
+}
+
+// kind returns the kind to store for t and whether it was taken from the kind overrides.
+func (o storeOptions) kind(t tld) (string, bool) {
+	return o.parse.kindOf(string(t))
+}
+
+// unfiltered returns the TLDs of stored which pass the length and kind filters.
+// The others weren't looked for in the source, hence they must not be taken for missing from it.
+func (o storeOptions) unfiltered(stored []tld) []tld {
+	ts := make([]tld, 0, len(stored))
+	for _, t := range stored {
+		if !o.parse.filtered(string(t)) {
+			ts = append(ts, t)
+		}
+	}
+	return ts
+}
+
+// sourceValue returns the source to store along with a TLD.
+func (o storeOptions) sourceValue() sql.NullString {
+	return sql.NullString{
+		String: o.source,
+		Valid:  o.source != "",
+	}
+}
+
+// hasOrdinals reports whether the position of TLDs in the source is meaningful,
+// which is only the case for the canonical plain-text list.
+func hasOrdinals(cfg config) bool {
+	return inputFormatFor(cfg.parse.inputFormat, cfg.source, "") == inputFormatText
+}
+
+// insertTLD inserts t along with its ordinal, source and kind using the prepared insert statement stmt.
+// It returns an error wrapping errAlreadyExists if t is stored already.
+func insertTLD(ctx context.Context, stmt *sql.Stmt, t tld, ordinal sql.NullInt64, source sql.NullString, kind string, kindOverridden bool) error {
+	if _, err := stmt.ExecContext(ctx, t, ordinal, source, kind, kindOverridden); err != nil {
+		var serr *sqlite.Error
+		if errors.As(err, &serr) && serr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY {
+			return fmt.Errorf("%w: %q", errAlreadyExists, t)
+		}
+		return fmt.Errorf("failed to insert %q: %w", t, err)
+	}
+
+	return nil
+}
+
+// readOnlyDSN returns the data source name opening the SQLite database at path read-only.
+func readOnlyDSN(path string) string {
+	return "file:" + path + "?mode=ro"
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err() //nolint:wrapcheck // Context errors are fine as-is
+	case <-t.C:
+		return nil
+	}
+}
+
+// syncWithDB stores tlds in db and reports the ones which were not stored yet as well as the ones which went missing.
+// The database schema is created if db doesn't contain it yet.
+// On error, the changes stored before failing are reported, unless opts.failFast rolled them back.
+// db is owned by the caller and is not closed.
+func syncWithDB(
+	ctx context.Context,
+	l *slog.Logger,
+	db *sql.DB,
+	tlds []tld,
+	opts storeOptions,
+) (result, error) {
+	initialized, err := ensureSchema(ctx, l, db)
+	if err != nil {
+		return result{}, err
+	}
+
+	// TLDs are never deleted in this mode, instead the ones missing from the source are marked as removed.
+	// Marked TLDs are reported only once, i.e. when they go missing, and as added again once they're listed again.
+	stored, err := queryTLDs(ctx, db, sqliteLiveSelectStmt)
+	if err != nil {
+		return result{}, err
+	}
+	marked, err := queryTLDs(ctx, db, sqliteRemovedSelectStmt)
+	if err != nil {
+		return result{}, err
+	}
+	retired := make(map[tld]struct{}, len(marked))
+	for _, t := range marked {
+		retired[t] = struct{}{}
+	}
+	removed := diffTLDs(opts.unfiltered(stored), tlds).removed
+
+	// Unless conflicts are ignored, inserting doesn't tell whether a TLD was stored already
+	existing := make(map[tld]struct{}, len(stored))
+	for _, t := range stored {
+		existing[t] = struct{}{}
+	}
+
+	// Failing fast must not leave a partially-stored set behind, hence insert in a single transaction then
+	var (
+		p  preparer = db
+		tx *sql.Tx
+	)
+	if opts.failFast {
+		if tx, err = db.BeginTx(ctx, nil); err != nil {
+			return result{}, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer func() {
+			if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+				l.ErrorContext(ctx, fmt.Errorf("failed to roll back transaction: %w", err).Error())
+			}
+		}()
+		p = tx
+	}
+
+	stmt, err := p.PrepareContext(ctx, opts.insertStmt())
+	if err != nil {
+		return result{}, fmt.Errorf("failed to prepare insert statement: %w", err)
+	}
+	defer func() {
+		if err := stmt.Close(); err != nil {
+			l.ErrorContext(ctx, fmt.Errorf("failed to close insert statement: %w", err).Error())
+		}
+	}()
+
+	markStmt, err := p.PrepareContext(ctx, sqliteRemovedSetStmt)
+	if err != nil {
+		return result{}, fmt.Errorf("failed to prepare removal statement: %w", err)
+	}
+	defer func() {
+		if err := markStmt.Close(); err != nil {
+			l.ErrorContext(ctx, fmt.Errorf("failed to close removal statement: %w", err).Error())
+		}
+	}()
+
+	seenStmt, err := p.PrepareContext(ctx, sqliteLastSeenSetStmt)
+	if err != nil {
+		return result{}, fmt.Errorf("failed to prepare last seen statement: %w", err)
+	}
+	defer func() {
+		if err := seenStmt.Close(); err != nil {
+			l.ErrorContext(ctx, fmt.Errorf("failed to close last seen statement: %w", err).Error())
+		}
+	}()
+
+	res := result{
+		InitialImport: initialized,
+		Added:         make([]tld, 0, len(tlds)),
+		Removed:       make([]tld, 0, len(removed)),
+	}
+	// Without a transaction, rows are committed one by one, hence a failure leaves the changes made so far behind
+	partial := func() result {
+		if tx != nil {
+			return result{}
+		}
+		return res
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	for i, tld := range tlds {
+		opts.prog.update(i)
+
+		kind, overridden := opts.kind(tld)
+		// TLDs which are stored already are fine
+		if err := insertTLD(context.WithoutCancel(ctx), stmt, tld, opts.ordinal(i), opts.sourceValue(), kind, overridden); err != nil && !errors.Is(err, errAlreadyExists) {
+			if opts.failFast {
+				return partial(), err
+			}
+
+			l.ErrorContext(
+				ctx,
+				"failed to exec insert statement",
+				"err", err,
+				"tld", fmt.Sprintf("%+v", tld),
+			)
+			continue
+		}
+		if _, err := seenStmt.ExecContext(context.WithoutCancel(ctx), now, tld); err != nil {
+			return partial(), fmt.Errorf("failed to update last seen of %q: %w", tld, err)
+		}
+
+		if _, ok := retired[tld]; ok {
+			if _, err := markStmt.ExecContext(context.WithoutCancel(ctx), nil, tld); err != nil {
+				return partial(), fmt.Errorf("failed to unmark %q as removed: %w", tld, err)
+			}
+			delete(retired, tld)
+
+			res.Added = append(res.Added, tld)
+			continue
+		}
+		if _, ok := existing[tld]; ok {
+			continue
+		}
+		existing[tld] = struct{}{}
+
+		res.Added = append(res.Added, tld)
+	}
+	opts.prog.update(len(tlds))
+
+	for _, t := range removed {
+		if _, err := markStmt.ExecContext(context.WithoutCancel(ctx), now, t); err != nil {
+			return partial(), fmt.Errorf("failed to mark %q as removed: %w", t, err)
+		}
+		res.Removed = append(res.Removed, t)
+	}
+
+	if tx != nil {
+		if err := tx.Commit(); err != nil {
+			return result{}, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+	}
+
+	return res, nil
+}
+
+// swapWithDB replaces the stored TLDs by tlds in a single transaction and reports the ones which were not stored yet.
+// The new set is loaded into a separate table first which is then swapped into place,
+// hence readers either see the old or the new set but never a partially-updated one.
+// Stored TLDs which are missing from tlds are dropped, unless tlds is implausibly small, see checkPlausible.
+func swapWithDB(
+	ctx context.Context,
+	l *slog.Logger,
+	db *sql.DB,
+	tlds []tld,
+	opts storeOptions,
+) (result, error) {
+	initialized, err := ensureSchema(ctx, l, db)
+	if err != nil {
+		return result{}, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return result{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			l.ErrorContext(ctx, fmt.Errorf("failed to roll back transaction: %w", err).Error())
+		}
+	}()
+
+	stored, err := queryTLDs(ctx, tx, sqliteLiveSelectStmt)
+	if err != nil {
+		return result{}, err
+	}
+	unfiltered := opts.unfiltered(stored)
+	if err := checkPlausible(len(unfiltered), len(tlds)); err != nil {
+		return result{}, err
+	}
+
+	if _, err := tx.ExecContext(ctx, sqliteSwapCreateStmt); err != nil {
+		return result{}, fmt.Errorf("failed to create swap table: %w", err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, sqliteSwapInsertStmt)
+	if err != nil {
+		return result{}, fmt.Errorf("failed to prepare swap insert statement: %w", err)
+	}
+	defer func() {
+		if err := stmt.Close(); err != nil {
+			l.ErrorContext(ctx, fmt.Errorf("failed to close swap insert statement: %w", err).Error())
+		}
+	}()
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	for i, tld := range tlds {
+		opts.prog.update(i)
+
+		kind, overridden := opts.kind(tld)
+		if _, err := stmt.ExecContext(ctx, tld, opts.ordinal(i), opts.sourceValue(), now, kind, overridden); err != nil {
+			return result{}, fmt.Errorf("failed to insert %q into swap table: %w", tld, err)
+		}
+	}
+	opts.prog.update(len(tlds))
+
+	// TLDs left out by the filters are kept as-is instead of being swapped out
+	if len(unfiltered) < len(stored) {
+		for _, t := range stored {
+			if !opts.parse.filtered(string(t)) {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, sqliteSwapKeepStmt, t); err != nil {
+				return result{}, fmt.Errorf("failed to keep %q in swap table: %w", t, err)
+			}
+		}
+	}
+
+	newTLDs, err := queryTLDs(ctx, tx, sqliteSwapAddedStmt)
+	if err != nil {
+		return result{}, err
+	}
+	removed, err := queryTLDs(ctx, tx, sqliteSwapRemovedStmt)
+	if err != nil {
+		return result{}, err
+	}
+
+	if _, err := tx.ExecContext(ctx, sqliteSwapStmt); err != nil {
+		return result{}, fmt.Errorf("failed to swap tables: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return result{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return result{
+		InitialImport: initialized,
+		Added:         newTLDs,
+		Removed:       removed,
+	}, nil
+}
+
+// ensureSchema creates the database schema unless db already contains it, in which case pending migrations are applied.
+// It reports whether the schema was created.
+func ensureSchema(ctx context.Context, l *slog.Logger, db *sql.DB) (bool, error) {
+	var hasSchema bool
+	if err := db.QueryRowContext(ctx, sqliteHasSchemaStmt).Scan(&hasSchema); err != nil {
+		return false, fmt.Errorf("failed to check for database schema: %w", err)
+	}
+	if hasSchema {
+		return false, migrateSchema(ctx, l, db)
+	}
+
+	// The initial schema is version 1, later versions are reached by migrating
+	if _, err := db.ExecContext(ctx, sqliteInitStmt); err != nil {
+		return false, fmt.Errorf("failed to init database: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, "pragma user_version = 1"); err != nil {
+		return false, fmt.Errorf("failed to set schema version: %w", err)
+	}
+	if err := migrateSchema(ctx, l, db); err != nil {
+		return false, err
+	}
+	l.InfoContext(ctx, "successfully initialized database")
+
+	return true, nil
+}
+
+// preparer is implemented by both *sql.DB and *sql.Tx.
+type preparer interface {
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func queryTLDs(ctx context.Context, q queryer, query string) ([]tld, error) {
+	rows, err := q.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query TLDs: %w", err)
+	}
+	defer rows.Close() //nolint:errcheck // rows.Err is checked below
+
+	tlds := make([]tld, 0)
+	for rows.Next() {
+		var t tld
+		if err := rows.Scan(&t); err != nil {
+			return nil, fmt.Errorf("failed to scan TLD: %w", err)
+		}
+		tlds = append(tlds, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate TLDs: %w", err)
+	}
+
+	return tlds, nil
+}
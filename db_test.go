@@ -0,0 +1,665 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newMemoryDB(tb testing.TB) *sql.DB {
+	tb.Helper()
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		tb.Fatalf("failed to open database: %v", err)
+	}
+	tb.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			tb.Errorf("failed to close database: %v", err)
+		}
+	})
+	// Every connection to ":memory:" gets its own database
+	db.SetMaxOpenConns(1)
+
+	return db
+}
+
+func TestSyncWithDB(t *testing.T) {
+	t.Parallel()
+
+	db := newMemoryDB(t)
+
+	res, err := syncWithDB(t.Context(), newTestLogger(), db, []tld{"com", "net"}, storeOptions{})
+	if err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if got, want := len(res.Added), 2; got != want {
+		t.Fatalf("got %d added TLDs, want %d", got, want)
+	}
+	if !res.InitialImport {
+		t.Fatal("first sync is not reported as initial import")
+	}
+
+	res, err = syncWithDB(t.Context(), newTestLogger(), db, []tld{"com", "net", "org"}, storeOptions{})
+	if err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if want := []tld{"org"}; !slices.Equal(res.Added, want) {
+		t.Fatalf("got added TLDs %v, want %v", res.Added, want)
+	}
+	if res.InitialImport {
+		t.Fatal("second sync is reported as initial import")
+	}
+
+	// The caller still owns the database
+	if err := db.PingContext(t.Context()); err != nil {
+		t.Fatalf("database is not usable anymore: %v", err)
+	}
+}
+
+func TestSyncWithDBFailFast(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name     string
+		failFast bool
+		stored   int
+	}{
+		{"continue", false, 2},
+		{"fail-fast", true, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db := newMemoryDB(t)
+			if _, err := ensureSchema(t.Context(), newTestLogger(), db); err != nil {
+				t.Fatal(err)
+			}
+			// Make inserting a specific TLD fail unexpectedly
+			if _, err := db.ExecContext(t.Context(), `
+				create trigger fail before insert on tlds when new.tld = 'bad' begin select raise(abort, 'boom'); end;
+			`); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := syncWithDB(t.Context(), newTestLogger(), db, []tld{"com", "bad", "net"}, storeOptions{failFast: tc.failFast})
+			if gotErr := err != nil; gotErr != tc.failFast {
+				t.Fatalf("got error %v, want one: %t", err, tc.failFast)
+			}
+
+			var count int
+			if err := db.QueryRowContext(t.Context(), "select count(*) from tlds").Scan(&count); err != nil {
+				t.Fatalf("failed to count TLDs: %v", err)
+			}
+			if count != tc.stored {
+				t.Fatalf("got %d stored TLDs, want %d", count, tc.stored)
+			}
+		})
+	}
+}
+
+func TestSyncWithDBPartial(t *testing.T) {
+	t.Parallel()
+
+	db := newMemoryDB(t)
+	if _, err := syncWithDB(t.Context(), newTestLogger(), db, []tld{"com", "net"}, storeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(t.Context(), "create trigger fail before update of removed_at on tlds when new.removed_at is not null begin select raise(abort, 'boom'); end"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		failFast bool
+		want     []tld
+	}{
+		// With -fail-fast, nothing is left behind
+		{true, nil},
+		// Without it, the inserts are committed before marking the removal fails
+		{false, []tld{"org"}},
+	} {
+		res, err := syncWithDB(t.Context(), newTestLogger(), db, []tld{"com", "org"}, storeOptions{failFast: tc.failFast})
+		if err == nil {
+			t.Fatalf("fail fast %t: got no error", tc.failFast)
+		}
+		if !slices.Equal(res.Added, tc.want) {
+			t.Errorf("fail fast %t: got added TLDs %v, want %v", tc.failFast, res.Added, tc.want)
+		}
+	}
+}
+
+func TestInsertTLD(t *testing.T) {
+	t.Parallel()
+
+	db := newMemoryDB(t)
+	if _, err := ensureSchema(t.Context(), newTestLogger(), db); err != nil {
+		t.Fatal(err)
+	}
+
+	stmt, err := db.PrepareContext(t.Context(), sqliteInsertStmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := stmt.Close(); err != nil {
+			t.Errorf("failed to close statement: %v", err)
+		}
+	})
+
+	if err := insertTLD(t.Context(), stmt, "com", sql.NullInt64{}, sql.NullString{}, kindGeneric, false); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := insertTLD(t.Context(), stmt, "com", sql.NullInt64{}, sql.NullString{}, kindGeneric, false); !errors.Is(err, errAlreadyExists) {
+		t.Fatalf("got error %v inserting a duplicate, want %v", err, errAlreadyExists)
+	}
+}
+
+func TestSwapWithDB(t *testing.T) {
+	t.Parallel()
+
+	db := newMemoryDB(t)
+
+	res, err := swapWithDB(t.Context(), newTestLogger(), db, []tld{"com", "net", "org"}, storeOptions{})
+	if err != nil {
+		t.Fatalf("failed to swap: %v", err)
+	}
+	if want := []tld{"com", "net", "org"}; !slices.Equal(res.Added, want) {
+		t.Fatalf("got added TLDs %v, want %v", res.Added, want)
+	}
+
+	res, err = swapWithDB(t.Context(), newTestLogger(), db, []tld{"com", "org", "org", "рф"}, storeOptions{})
+	if err != nil {
+		t.Fatalf("failed to swap: %v", err)
+	}
+	if want := []tld{"рф"}; !slices.Equal(res.Added, want) {
+		t.Fatalf("got added TLDs %v, want %v", res.Added, want)
+	}
+
+	// A broken source must not wipe the stored TLDs
+	for _, tlds := range [][]tld{{}, {"com"}} {
+		if _, err := swapWithDB(t.Context(), newTestLogger(), db, tlds, storeOptions{}); !errors.Is(err, errImplausibleSource) {
+			t.Fatalf("%v: got error %v, want %v", tlds, err, errImplausibleSource)
+		}
+	}
+
+	var count int
+	if err := db.QueryRowContext(t.Context(), "select count(*) from tlds").Scan(&count); err != nil {
+		t.Fatalf("failed to count TLDs: %v", err)
+	}
+	if got, want := count, 3; got != want {
+		t.Fatalf("got %d stored TLDs, want %d", got, want)
+	}
+}
+
+func BenchmarkSwapWithDB(b *testing.B) {
+	tlds := make([]tld, 0, 1500)
+	for i := range cap(tlds) {
+		tlds = append(tlds, tld("tld"+strconv.Itoa(i)))
+	}
+
+	db := newMemoryDB(b)
+	l := newTestLogger()
+
+	for b.Loop() {
+		if _, err := swapWithDB(b.Context(), l, db, tlds, storeOptions{}); err != nil {
+			b.Fatalf("failed to swap: %v", err)
+		}
+	}
+}
+
+func TestSyncWithDBOrdinals(t *testing.T) {
+	t.Parallel()
+
+	ordinals := func(t *testing.T, db *sql.DB) map[tld]sql.NullInt64 {
+		t.Helper()
+
+		rows, err := db.QueryContext(t.Context(), "select tld, ordinal from tlds")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close() //nolint:errcheck // rows.Err is checked below
+
+		m := make(map[tld]sql.NullInt64)
+		for rows.Next() {
+			var (
+				tl tld
+				o  sql.NullInt64
+			)
+			if err := rows.Scan(&tl, &o); err != nil {
+				t.Fatal(err)
+			}
+			m[tl] = o
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	db := newMemoryDB(t)
+	if _, err := syncWithDB(t.Context(), newTestLogger(), db, []tld{"aaa", "com"}, storeOptions{ordinals: true}); err != nil {
+		t.Fatal(err)
+	}
+	// Ordinals are only recorded on insert
+	if _, err := syncWithDB(t.Context(), newTestLogger(), db, []tld{"com", "net"}, storeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[tld]sql.NullInt64{
+		"aaa": {Int64: 1, Valid: true},
+		"com": {Int64: 2, Valid: true},
+		"net": {},
+	}
+	if got := ordinals(t, db); !maps.Equal(got, want) {
+		t.Fatalf("got ordinals %v, want %v", got, want)
+	}
+
+	// Unless conflicts are ignored, ordinals of stored TLDs are updated as well
+	for _, tc := range []struct {
+		policy string
+		added  []tld
+	}{
+		{conflictReplace, []tld{"org"}},
+		{conflictUpdate, []tld{}},
+	} {
+		res, err := syncWithDB(t.Context(), newTestLogger(), db, []tld{"net", "com", "org"}, storeOptions{
+			ordinals: true,
+			conflict: tc.policy,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(res.Added, tc.added) {
+			t.Fatalf("%s: got added TLDs %v, want %v", tc.policy, res.Added, tc.added)
+		}
+
+		want := map[tld]sql.NullInt64{
+			"aaa": {Int64: 1, Valid: true},
+			"net": {Int64: 1, Valid: true},
+			"com": {Int64: 2, Valid: true},
+			"org": {Int64: 3, Valid: true},
+		}
+		if got := ordinals(t, db); !maps.Equal(got, want) {
+			t.Fatalf("%s: got ordinals %v, want %v", tc.policy, got, want)
+		}
+	}
+}
+
+func TestStoreSource(t *testing.T) {
+	t.Parallel()
+
+	sources := func(t *testing.T, db *sql.DB) map[tld]string {
+		t.Helper()
+
+		rows, err := db.QueryContext(t.Context(), "select tld, source_url from tlds")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close() //nolint:errcheck // rows.Err is checked below
+
+		m := make(map[tld]string)
+		for rows.Next() {
+			var (
+				tl tld
+				s  string
+			)
+			if err := rows.Scan(&tl, &s); err != nil {
+				t.Fatal(err)
+			}
+			m[tl] = s
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	for _, tc := range []struct {
+		name     string
+		store    func(context.Context, *slog.Logger, *sql.DB, []tld, storeOptions) (result, error)
+		conflict string
+	}{
+		{"sync", syncWithDB, conflictIgnore},
+		{"sync-replace", syncWithDB, conflictReplace},
+		{"sync-update", syncWithDB, conflictUpdate},
+		{"swap", swapWithDB, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db := newMemoryDB(t)
+			if _, err := tc.store(t.Context(), newTestLogger(), db, []tld{"com"}, storeOptions{source: "first", conflict: tc.conflict}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tc.store(t.Context(), newTestLogger(), db, []tld{"com", "net"}, storeOptions{source: "second", conflict: tc.conflict}); err != nil {
+				t.Fatal(err)
+			}
+
+			// The source which supplied a TLD first is kept
+			want := map[tld]string{"com": "first", "net": "second"}
+			if got := sources(t, db); !maps.Equal(got, want) {
+				t.Fatalf("got sources %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestStoreLastSeen(t *testing.T) {
+	t.Parallel()
+
+	const old = "2000-01-01T00:00:00Z"
+
+	lastSeen := func(t *testing.T, db *sql.DB) map[tld]string {
+		t.Helper()
+
+		rows, err := db.QueryContext(t.Context(), "select tld, last_seen from tlds")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close() //nolint:errcheck // rows.Err is checked below
+
+		m := make(map[tld]string)
+		for rows.Next() {
+			var (
+				tl tld
+				s  string
+			)
+			if err := rows.Scan(&tl, &s); err != nil {
+				t.Fatal(err)
+			}
+			m[tl] = s
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	for _, tc := range []struct {
+		name  string
+		store func(context.Context, *slog.Logger, *sql.DB, []tld, storeOptions) (result, error)
+		opts  storeOptions
+		// keeps reports whether TLDs missing from the source are kept
+		keeps bool
+	}{
+		{"sync", syncWithDB, storeOptions{}, true},
+		{"sync-fail-fast", syncWithDB, storeOptions{failFast: true}, true},
+		{"sync-replace", syncWithDB, storeOptions{conflict: conflictReplace}, true},
+		{"swap", swapWithDB, storeOptions{}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db := newMemoryDB(t)
+			if _, err := tc.store(t.Context(), newTestLogger(), db, []tld{"com", "net"}, tc.opts); err != nil {
+				t.Fatal(err)
+			}
+			for tl, s := range lastSeen(t, db) {
+				if _, err := time.Parse(time.RFC3339, s); err != nil {
+					t.Fatalf("got last seen %q of %q, want an RFC 3339 timestamp", s, tl)
+				}
+			}
+
+			if _, err := db.ExecContext(t.Context(), "update tlds set last_seen = ?", old); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tc.store(t.Context(), newTestLogger(), db, []tld{"com"}, tc.opts); err != nil {
+				t.Fatal(err)
+			}
+
+			got := lastSeen(t, db)
+			if got["com"] == old {
+				t.Errorf("got last seen %q of com, want it to be updated", got["com"])
+			}
+			if s, ok := got["net"]; ok != tc.keeps || (ok && s != old) {
+				t.Errorf("got last seen %q of net, want it to be kept at %q", s, old)
+			}
+		})
+	}
+}
+
+func TestSyncWithDBLastSeenFailedInsert(t *testing.T) {
+	t.Parallel()
+
+	const old = "2000-01-01T00:00:00Z"
+
+	db := newMemoryDB(t)
+	opts := storeOptions{conflict: conflictUpdate}
+	if _, err := syncWithDB(t.Context(), newTestLogger(), db, []tld{"com", "net"}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(t.Context(), "update tlds set last_seen = ?", old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(t.Context(), "create trigger fail before update of ordinal on tlds when new.tld = 'net' begin select raise(abort, 'boom'); end"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Failing to store net is logged only
+	if _, err := syncWithDB(t.Context(), newTestLogger(), db, []tld{"com", "net"}, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	var com, net string
+	if err := db.QueryRowContext(t.Context(), "select (select last_seen from tlds where tld = 'com'), (select last_seen from tlds where tld = 'net')").Scan(&com, &net); err != nil {
+		t.Fatal(err)
+	}
+	if com == old || net != old {
+		t.Fatalf("got last seen %q of com and %q of net, want only com to be updated", com, net)
+	}
+}
+
+func TestEnsureDBDir(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "data", "nested")
+	sqliteFile := filepath.Join(dir, "db.sqlite")
+
+	if err := ensureDBDir(sqliteFile, false, 0o750); !errors.Is(err, errMissingDBDir) {
+		t.Fatalf("expected errMissingDBDir, got %v", err)
+	}
+
+	if err := ensureDBDir(sqliteFile, true, 0o750); err != nil {
+		t.Fatalf("failed to create database directory: %v", err)
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		t.Fatalf("failed to stat database directory: %v", err)
+	}
+	if !fi.IsDir() {
+		t.Errorf("expected %q to be a directory", dir)
+	}
+
+	if err := ensureDBDir(sqliteFile, false, 0o750); err != nil {
+		t.Errorf("expected existing directory to be accepted, got %v", err)
+	}
+}
+
+// lockDB holds an exclusive lock on the database at path until the returned function is called.
+func lockDB(t *testing.T, path string) func() {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	conn, err := db.Conn(t.Context())
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	if _, err := conn.ExecContext(t.Context(), "create table lock (x); begin exclusive; insert into lock values (1)"); err != nil {
+		t.Fatalf("failed to lock database: %v", err)
+	}
+
+	return func() {
+		_, _ = conn.ExecContext(context.Background(), "commit")
+		_ = conn.Close()
+	}
+}
+
+func TestOpenDBRetry(t *testing.T) {
+	t.Parallel()
+
+	const retryMsg = "failed to reach database, retrying"
+
+	t.Run("busy", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "db.sqlite")
+		unlock := lockDB(t, path)
+		time.AfterFunc(50*time.Millisecond, unlock)
+
+		var buf bytes.Buffer
+		l := slog.New(slog.NewJSONHandler(&buf, nil))
+		db, err := openDB(t.Context(), l, path, 100, 10*time.Millisecond)
+		if err != nil {
+			t.Fatalf("failed to open database: %v", err)
+		}
+		t.Cleanup(func() { _ = db.Close() })
+
+		if !strings.Contains(buf.String(), retryMsg) {
+			t.Errorf("got log %q, want it to contain %q", buf.String(), retryMsg)
+		}
+	})
+
+	t.Run("exhausted", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "db.sqlite")
+		t.Cleanup(lockDB(t, path))
+
+		var buf bytes.Buffer
+		l := slog.New(slog.NewJSONHandler(&buf, nil))
+		_, err := openDB(t.Context(), l, path, 2, time.Millisecond)
+		if err == nil || !strings.HasPrefix(err.Error(), "failed to reach database: ") {
+			t.Fatalf("got error %v, want failure to reach database", err)
+		}
+
+		if got := strings.Count(buf.String(), retryMsg); got != 2 {
+			t.Errorf("got %d retries, want 2", got)
+		}
+	})
+}
+
+func TestStoreKind(t *testing.T) {
+	t.Parallel()
+
+	type kind struct {
+		kind       string
+		overridden bool
+	}
+
+	kinds := func(t *testing.T, db *sql.DB) map[tld]kind {
+		t.Helper()
+
+		rows, err := db.QueryContext(t.Context(), "select tld, kind, kind_overridden from tlds")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close() //nolint:errcheck // rows.Err is checked below
+
+		m := make(map[tld]kind)
+		for rows.Next() {
+			var (
+				tl tld
+				k  kind
+			)
+			if err := rows.Scan(&tl, &k.kind, &k.overridden); err != nil {
+				t.Fatal(err)
+			}
+			m[tl] = k
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	opts := storeOptions{parse: parseOptions{kindOverrides: map[string]string{"рф": kindCC}}}
+	for _, tc := range []struct {
+		name  string
+		store func(context.Context, *slog.Logger, *sql.DB, []tld, storeOptions) (result, error)
+	}{
+		{"sync", syncWithDB},
+		{"swap", swapWithDB},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db := newMemoryDB(t)
+			if _, err := tc.store(t.Context(), newTestLogger(), db, []tld{"com", "uk", "рф"}, opts); err != nil {
+				t.Fatal(err)
+			}
+
+			want := map[tld]kind{
+				"com": {kindGeneric, false},
+				"uk":  {kindCC, false},
+				"рф":  {kindCC, true},
+			}
+			if got := kinds(t, db); !maps.Equal(got, want) {
+				t.Fatalf("got kinds %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestStoreFiltered(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name  string
+		store func(context.Context, *slog.Logger, *sql.DB, []tld, storeOptions) (result, error)
+	}{
+		{"sync", syncWithDB},
+		{"swap", swapWithDB},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db := newMemoryDB(t)
+			if _, err := tc.store(t.Context(), newTestLogger(), db, []tld{"com", "de", "photography", "uk"}, storeOptions{}); err != nil {
+				t.Fatal(err)
+			}
+
+			// The longer TLDs were left out of the source by the filter, they didn't go missing from it
+			opts := storeOptions{parse: parseOptions{maxTLDLength: 2}}
+			res, err := tc.store(t.Context(), newTestLogger(), db, []tld{"uk"}, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []tld{"de"}; !slices.Equal(res.Removed, want) {
+				t.Fatalf("got removed %v, want %v", res.Removed, want)
+			}
+
+			stored, err := queryTLDs(t.Context(), db, sqliteLiveSelectStmt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []tld{"com", "photography", "uk"}; !slices.Equal(slices.Sorted(slices.Values(stored)), want) {
+				t.Fatalf("got stored %v, want %v", stored, want)
+			}
+
+			// So were the generic ones
+			if res, err = tc.store(t.Context(), newTestLogger(), db, []tld{"uk"}, storeOptions{parse: parseOptions{onlyCC: true}}); err != nil {
+				t.Fatal(err)
+			}
+			if len(res.Removed) != 0 {
+				t.Fatalf("got removed %v with only-cc, want none", res.Removed)
+			}
+			if stored, err = queryTLDs(t.Context(), db, sqliteLiveSelectStmt); err != nil || len(stored) != 3 {
+				t.Fatalf("got stored %v and error %v with only-cc, want 3 TLDs", stored, err)
+			}
+		})
+	}
+}
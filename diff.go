@@ -0,0 +1,113 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+)
+
+// tldDiff is the difference between two sets of TLDs.
+type tldDiff struct {
+	added   []tld
+	removed []tld
+}
+
+// diffTLDs compares the TLD sets prev and next.
+// TLDs are compared in their NFC normal form, so differently composed but canonically equivalent TLDs are equal.
+// The result doesn't depend on the order of prev and next and is sorted.
+func diffTLDs(prev, next []tld) tldDiff {
+	prevSet := normalizedSet(prev)
+	nextSet := normalizedSet(next)
+
+	d := tldDiff{
+		added:   make([]tld, 0),
+		removed: make([]tld, 0),
+	}
+	for k, t := range nextSet {
+		if _, ok := prevSet[k]; !ok {
+			d.added = append(d.added, t)
+		}
+	}
+	for k, t := range prevSet {
+		if _, ok := nextSet[k]; !ok {
+			d.removed = append(d.removed, t)
+		}
+	}
+	slices.Sort(d.added)
+	slices.Sort(d.removed)
+
+	return d
+}
+
+func normalizedSet(tlds []tld) map[string]tld {
+	m := make(map[string]tld, len(tlds))
+	for _, t := range tlds {
+		k := norm.NFC.String(string(t))
+		if _, ok := m[k]; !ok {
+			m[k] = t
+		}
+	}
+	return m
+}
+
+// idnaACEPrefix is the prefix of the ASCII form of IDN labels.
+const idnaACEPrefix = "xn--"
+
+// tldChange is a TLD whose Unicode form changed while its ASCII form stayed the same.
+type tldChange struct {
+	ASCII string `json:"ascii"`
+	From  tld    `json:"from"`
+	To    tld    `json:"to"`
+}
+
+// extractChanged moves TLDs which are both added and removed under the same ASCII form from r.Added and r.Removed to r.Changed.
+// This happens if the Unicode form of an IDN TLD changes, e.g. due to an IANA correction or an IDNA library update.
+func (r *result) extractChanged() {
+	if len(r.Added) == 0 || len(r.Removed) == 0 {
+		return
+	}
+
+	// Map the labels so that e.g. differently cased forms share the same ASCII form
+	prof := idna.New(idna.MapForLookup(), idna.BidiRule())
+	toASCII := func(t tld) (string, bool) {
+		a, err := prof.ToASCII(string(t))
+		// ASCII TLDs don't have a Unicode form which could change
+		return a, err == nil && strings.HasPrefix(a, idnaACEPrefix)
+	}
+
+	removedByASCII := make(map[string]tld, len(r.Removed))
+	for _, t := range r.Removed {
+		if a, ok := toASCII(t); ok {
+			removedByASCII[a] = t
+		}
+	}
+
+	changedFrom := make(map[tld]struct{})
+	added := make([]tld, 0, len(r.Added))
+	for _, t := range r.Added {
+		a, ok := toASCII(t)
+		from, changed := removedByASCII[a]
+		if !ok || !changed {
+			added = append(added, t)
+			continue
+		}
+
+		r.Changed = append(r.Changed, tldChange{
+			ASCII: a,
+			From:  from,
+			To:    t,
+		})
+		changedFrom[from] = struct{}{}
+	}
+	if len(r.Changed) == 0 {
+		return
+	}
+
+	r.Added = added
+	r.Removed = slices.DeleteFunc(slices.Clone(r.Removed), func(t tld) bool {
+		_, ok := changedFrom[t]
+		return ok
+	})
+}
@@ -0,0 +1,57 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDiffTLDs(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name          string
+		prev, next    []tld
+		added, remove []tld
+	}{
+		{"empty", nil, nil, nil, nil},
+		{"initial", nil, []tld{"net", "com"}, []tld{"com", "net"}, nil},
+		{"all-removed", []tld{"net", "com"}, nil, nil, []tld{"com", "net"}},
+		{"unchanged", []tld{"com", "net"}, []tld{"net", "com"}, nil, nil},
+		{"mixed", []tld{"com", "net", "xxx"}, []tld{"org", "com", "рф"}, []tld{"org", "рф"}, []tld{"net", "xxx"}},
+		{"duplicates", []tld{"com", "com"}, []tld{"net", "net", "com"}, []tld{"net"}, nil},
+		// "e" followed by a combining acute accent is canonically equivalent to "é"
+		{"normalization", []tld{"caf\u00e9"}, []tld{"cafe\u0301"}, nil, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			d := diffTLDs(tc.prev, tc.next)
+			if !slices.Equal(d.added, tc.added) {
+				t.Errorf("got added %v, want %v", d.added, tc.added)
+			}
+			if !slices.Equal(d.removed, tc.remove) {
+				t.Errorf("got removed %v, want %v", d.removed, tc.remove)
+			}
+		})
+	}
+}
+
+func TestExtractChanged(t *testing.T) {
+	t.Parallel()
+
+	res := result{
+		Added:   []tld{"com", "öko"},
+		Removed: []tld{"ÖKO", "net", "рф"},
+	}
+	res.extractChanged()
+
+	if want := []tld{"com"}; !slices.Equal(res.Added, want) {
+		t.Errorf("got added %v, want %v", res.Added, want)
+	}
+	if want := []tld{"net", "рф"}; !slices.Equal(res.Removed, want) {
+		t.Errorf("got removed %v, want %v", res.Removed, want)
+	}
+	if want := []tldChange{{ASCII: "xn--ko-eka", From: "ÖKO", To: "öko"}}; !slices.Equal(res.Changed, want) {
+		t.Errorf("got changed %v, want %v", res.Changed, want)
+	}
+}
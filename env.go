@@ -11,3 +11,10 @@ func getenv(key, fallback string) string {
 	}
 	return v
 }
+
+// expandEnvPaths replaces ${var} or $var in each of the paths by the value of the referenced environment variable.
+func expandEnvPaths(paths ...*string) {
+	for _, p := range paths {
+		*p = os.ExpandEnv(*p)
+	}
+}
@@ -0,0 +1,23 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"testing"
+)
+
+//nolint:paralleltest // t.Setenv can't be used in parallel tests
+func TestExpandEnvPaths(t *testing.T) {
+	t.Setenv("TLDWATCH_TEST_DIR", "/data")
+
+	a, b, c := "$TLDWATCH_TEST_DIR/db.sqlite", "${TLDWATCH_TEST_DIR}/out.json", "-"
+	expandEnvPaths(&a, &b, &c)
+
+	for _, tc := range []struct{ got, want string }{
+		{a, "/data/db.sqlite"},
+		{b, "/data/out.json"},
+		{c, "-"},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("got %q, want %q", tc.got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/net/idna"
+)
+
+// explainStep is a single stage a source line went through, see explain.
+type explainStep struct {
+	Line   int    `json:"line,omitempty"`
+	Step   string `json:"step"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// explanation is the trace of a single TLD through the pipeline.
+type explanation struct {
+	TLD   tld           `json:"tld"`
+	Steps []explainStep `json:"steps"`
+}
+
+// explain fetches and parses the source like a regular run but, instead of storing anything,
+// traces target through the parse stages and reports whether it's stored already.
+func explain(ctx context.Context, l *slog.Logger, w io.Writer, cfg config, target string) error {
+	prof := idna.New(idna.BidiRule())
+
+	// Accept the TLD in either form
+	target = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(target), "."))
+	if u, err := cfg.parse.storedForm(prof)(target); err == nil {
+		target = u
+	}
+
+	var steps []explainStep
+	if err := readSource(ctx, requestTimeout, l, cfg.source, cfg.fetch, cfg.parse, func(r io.Reader) error {
+		var err error
+		steps, err = traceTLD(ctx, l, r, cfg.parse, target)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	stored, err := explainStored(ctx, l, cfg, tld(target))
+	if err != nil {
+		return err
+	}
+	steps = append(steps, stored)
+
+	if err := writeExplanation(w, cfg.format, explanation{TLD: tld(target), Steps: steps}); err != nil {
+		return fmt.Errorf("failed to print explanation: %w", err)
+	}
+
+	return nil
+}
+
+// traceTLD runs r through scanTLDs and records the outcome of each stage for the tokens of r which are target.
+// Comment lines are recorded if any of their tokens is target.
+func traceTLD(ctx context.Context, l *slog.Logger, r io.Reader, opts parseOptions, target string) ([]explainStep, error) {
+	var (
+		steps   []explainStep
+		token   []explainStep
+		matched bool
+	)
+	flush := func() {
+		if matched {
+			steps = append(steps, token...)
+		}
+		token, matched = nil, false
+	}
+	opts.trace = func(s parseStep) {
+		if s.Step == traceStepFound {
+			flush()
+		}
+		token = append(token, explainStep{Line: s.Line, Step: s.Step, OK: s.OK, Detail: s.Detail})
+		if s.TLD == target || slices.Contains(opts.splitLine(strings.ToLower(s.Text)), target) {
+			matched = true
+		}
+	}
+
+	if err := scanTLDs(ctx, l, r, opts, func(tld, string) error { return nil }); err != nil {
+		return nil, err
+	}
+	flush()
+
+	if len(steps) == 0 {
+		steps = append(steps, explainStep{Step: traceStepFound, OK: false, Detail: "not listed in the source"})
+	}
+
+	return steps, nil
+}
+
+// explainStored reports whether t is stored in the database, which is opened read-only.
+func explainStored(ctx context.Context, l *slog.Logger, cfg config, t tld) (explainStep, error) {
+	step := explainStep{Step: "stored"}
+
+	if _, err := os.Stat(cfg.sqliteFile); errors.Is(err, os.ErrNotExist) {
+		step.Detail = "database doesn't exist yet"
+		return step, nil
+	}
+
+	db, err := openDB(ctx, l, readOnlyDSN(cfg.sqliteFile), cfg.dbOpenRetries, cfg.dbOpenRetryDelay)
+	if err != nil {
+		return explainStep{}, err
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			l.ErrorContext(ctx, fmt.Errorf("failed to close database: %w", err).Error())
+		}
+	}()
+
+	var hasSchema bool
+	if err := db.QueryRowContext(ctx, sqliteHasSchemaStmt).Scan(&hasSchema); err != nil {
+		return explainStep{}, fmt.Errorf("failed to check for database schema: %w", err)
+	}
+	if !hasSchema {
+		step.Detail = "database is not initialized yet"
+		return step, nil
+	}
+
+	stmt, err := liveStmt(ctx, db, sqliteLiveExistsStmt, sqliteExistsStmt)
+	if err != nil {
+		return explainStep{}, err
+	}
+	if err := db.QueryRowContext(ctx, stmt, t).Scan(&step.OK); err != nil {
+		return explainStep{}, fmt.Errorf("failed to look up %q: %w", t, err)
+	}
+
+	return step, nil
+}
+
+func writeExplanation(w io.Writer, format string, e explanation) error {
+	switch format {
+	case formatJSON, formatJSONObject:
+		return writeJSON(w, e)
+	case formatHuman:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, s := range e.Steps {
+			status := "ok"
+			if !s.OK {
+				status = "no"
+			}
+			line := ""
+			if s.Line > 0 {
+				line = fmt.Sprintf("line %d", s.Line)
+			}
+			if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", line, s.Step, status, s.Detail); err != nil {
+				return fmt.Errorf("failed to write: %w", err)
+			}
+		}
+		if err := tw.Flush(); err != nil {
+			return fmt.Errorf("failed to flush: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", errUnknownFormat, format)
+	}
+}
@@ -0,0 +1,94 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestConfig(fixturePath, filepath.Join(t.TempDir(), "db.sqlite"))
+	runToString(t, cfg)
+	cfg.parse.maxTLDLength = 3
+
+	for _, tc := range []struct {
+		target string
+		want   []string
+	}{
+		{"AAA", []string{"found", "lowercased", "not_comment", "punycode_decoded", "valid_label", "length", "kind", "emitted", "stored"}},
+		// Stored by the earlier run without a length limit
+		{"aarp", []string{"found", "lowercased", "not_comment", "punycode_decoded", "valid_label", "!length", "stored"}},
+		{"рф", []string{"found", "lowercased", "not_comment", "punycode_decoded", "valid_label", "length", "kind", "emitted", "stored"}},
+		{"invalid", []string{"!found", "!stored"}},
+	} {
+		t.Run(tc.target, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			if err := explain(t.Context(), newTestLogger(), &buf, cfg, tc.target); err != nil {
+				t.Fatalf("failed to explain: %v", err)
+			}
+
+			var e explanation
+			if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+				t.Fatalf("failed to decode output %q: %v", buf.String(), err)
+			}
+			got := make([]string, 0, len(e.Steps))
+			for _, s := range e.Steps {
+				if !s.OK {
+					s.Step = "!" + s.Step
+				}
+				got = append(got, s.Step)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Fatalf("got steps %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTraceTLDComment(t *testing.T) {
+	t.Parallel()
+
+	opts := parseOptions{maxLineSize: bufio.MaxScanTokenSize, multiPerLine: true}
+	const src = "# com net\nnet, org\norg\n"
+
+	for _, tc := range []struct {
+		target string
+		want   []string
+	}{
+		// Like scanTLDs, the whole line is skipped rather than its tokens
+		{"com", []string{"found", "!not_comment"}},
+		{"net", []string{"found", "!not_comment", "found", "lowercased", "not_comment", "punycode_decoded", "valid_label", "length", "kind", "emitted"}},
+		// The duplicate is skipped
+		{"org", []string{
+			"found", "lowercased", "not_comment", "punycode_decoded", "valid_label", "length", "kind", "emitted",
+			"found", "lowercased", "not_comment", "punycode_decoded", "valid_label", "length", "kind", "!not_duplicate",
+		}},
+	} {
+		t.Run(tc.target, func(t *testing.T) {
+			t.Parallel()
+
+			steps, err := traceTLD(t.Context(), newTestLogger(), strings.NewReader(src), opts, tc.target)
+			if err != nil {
+				t.Fatalf("failed to trace: %v", err)
+			}
+			got := make([]string, 0, len(steps))
+			for _, s := range steps {
+				if !s.OK {
+					s.Step = "!" + s.Step
+				}
+				got = append(got, s.Step)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Fatalf("got steps %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
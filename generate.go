@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+const cmdGenerate = "generate"
+
+const (
+	defaultGeneratePackage = "tlds"
+	generatedFilePerm      = 0o644
+)
+
+var (
+	errGenerateArgs   = errors.New("generate expects at most one output file")
+	errInvalidPackage = errors.New("invalid package name")
+)
+
+// generate writes a Go file declaring the stored TLDs as "var TLDs = []string{...}" in package pkg,
+// either to the file at args[0] or to w.
+// The version of the list and the Last-Modified header of the source as seen by the last run are included as constants if known.
+func generate(ctx context.Context, l *slog.Logger, w io.Writer, cfg config, pkg string, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("%w, got %d", errGenerateArgs, len(args))
+	}
+	if !token.IsIdentifier(pkg) {
+		return fmt.Errorf("%w: %q", errInvalidPackage, pkg)
+	}
+
+	if _, err := os.Stat(cfg.sqliteFile); errors.Is(err, os.ErrNotExist) {
+		return errNotInitialized
+	}
+
+	db, err := openDB(ctx, l, readOnlyDSN(cfg.sqliteFile), cfg.dbOpenRetries, cfg.dbOpenRetryDelay)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			l.ErrorContext(ctx, fmt.Errorf("failed to close database: %w", err).Error())
+		}
+	}()
+
+	var hasSchema bool
+	if err := db.QueryRowContext(ctx, sqliteHasSchemaStmt).Scan(&hasSchema); err != nil {
+		return fmt.Errorf("failed to check for database schema: %w", err)
+	}
+	if !hasSchema {
+		return errNotInitialized
+	}
+
+	stmt, err := liveStmt(ctx, db, sqliteLiveSelectStmt, sqliteSelectStmt)
+	if err != nil {
+		return err
+	}
+	tlds, err := queryTLDs(ctx, db, stmt)
+	if err != nil {
+		return err
+	}
+
+	// The database is opened read-only, hence it's not migrated and may lack the meta table
+	var (
+		meta    sourceMeta
+		version string
+	)
+	v, err := readSchemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if v >= 2 { //nolint:mnd // The meta table was added in version 2
+		if meta, err = loadSourceMeta(ctx, db); err != nil {
+			return err
+		}
+		if version, err = loadListVersion(ctx, db); err != nil {
+			return err
+		}
+	}
+
+	b, err := generateGo(pkg, tlds, version, meta.lastModified)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 || args[0] == stdoutPath {
+		if _, err := w.Write(b); err != nil {
+			return fmt.Errorf("failed to write: %w", err)
+		}
+		return nil
+	}
+
+	if err := writeFileAtomic(args[0], b, generatedFilePerm); err != nil {
+		return fmt.Errorf("failed to write generated file: %w", err)
+	}
+
+	return nil
+}
+
+// generateGo returns the formatted Go source declaring tlds in package pkg.
+func generateGo(pkg string, tlds []tld, version, lastModified string) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by tldwatch generate. DO NOT EDIT.\n\n")
+	buf.WriteString("package " + pkg + "\n\n")
+	if version != "" {
+		buf.WriteString("// Version is the version of the TLD list this file was generated from.\n")
+		buf.WriteString("const Version = " + strconv.Quote(version) + "\n\n")
+	}
+	if lastModified != "" {
+		buf.WriteString("// LastModified is the Last-Modified header of the TLD list this file was generated from.\n")
+		buf.WriteString("const LastModified = " + strconv.Quote(lastModified) + "\n\n")
+	}
+	buf.WriteString("// TLDs lists all known top-level domains, IDN ones in their Unicode form.\n")
+	buf.WriteString("var TLDs = []string{\n")
+	for _, t := range tlds {
+		buf.WriteString(strconv.Quote(string(t)) + ",\n")
+	}
+	buf.WriteString("}\n")
+
+	b, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format generated source: %w", err)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,48 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"bytes"
+	"errors"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestConfig(fixturePath, filepath.Join(t.TempDir(), "db.sqlite"))
+	runToString(t, cfg)
+
+	path := filepath.Join(t.TempDir(), "tlds.go")
+	var buf bytes.Buffer
+	if err := generate(t.Context(), newTestLogger(), &buf, cfg, "snapshot", []string{path}); err != nil {
+		t.Fatalf("failed to generate: %v", err)
+	}
+
+	b, err := os.ReadFile(path) //nolint:gosec // The path is a temporary file
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), path, b, 0)
+	if err != nil {
+		t.Fatalf("generated file doesn't parse: %v", err)
+	}
+	if got, want := f.Name.Name, "snapshot"; got != want {
+		t.Fatalf("got package %q, want %q", got, want)
+	}
+	if !strings.Contains(string(b), "\t\"aaa\",\n") {
+		t.Fatalf("generated file lacks the first TLD:\n%s", b)
+	}
+	// The version is taken from the header of the fixture
+	if !strings.Contains(string(b), "const Version = \"2025061000\"\n") {
+		t.Fatalf("generated file lacks the version of the list:\n%s", b)
+	}
+
+	if err := generate(t.Context(), newTestLogger(), &buf, cfg, "not-a-package", nil); !errors.Is(err, errInvalidPackage) {
+		t.Fatalf("got error %v, want %v", err, errInvalidPackage)
+	}
+}
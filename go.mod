@@ -4,6 +4,7 @@ go 1.24.2
 
 require (
 	golang.org/x/net v0.41.0
+	golang.org/x/text v0.26.0
 	modernc.org/sqlite v1.38.0
 )
 
@@ -15,7 +16,6 @@ require (
 	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
 	golang.org/x/exp v0.0.0-20250408133849-7e4ce0ab07d0 // indirect
 	golang.org/x/sys v0.33.0 // indirect
-	golang.org/x/text v0.26.0 // indirect
 	modernc.org/libc v1.65.10 // indirect
 	modernc.org/mathutil v1.7.1 // indirect
 	modernc.org/memory v1.11.0 // indirect
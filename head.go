@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+)
+
+// Keys of the source metadata in the meta table.
+const (
+	metaKeyContentLength = "source_content_length"
+	metaKeyLastModified  = "source_last_modified"
+	// metaKeyTLDCount is the number of stored TLDs as of the run which saved the source metadata
+	metaKeyTLDCount = "stored_tld_count"
+	// metaKeyListVersion is the version of the list stated by the source as of the last run, see listVersion
+	metaKeyListVersion = "list_version"
+)
+
+// sourceMeta is the metadata of a remote source as reported by a HEAD request.
+type sourceMeta struct {
+	contentLength string
+	lastModified  string
+}
+
+func (m sourceMeta) empty() bool {
+	return m.contentLength == "" && m.lastModified == ""
+}
+
+// sourceUnchanged issues a HEAD request against the remote source and compares its metadata with the stored one.
+// It returns the current metadata, which is empty if the HEAD request failed or the source doesn't provide any,
+// and whether the source is known to be unchanged.
+// A failing HEAD request is not an error, the source is then simply downloaded in full.
+func sourceUnchanged(
+	ctx context.Context,
+	l *slog.Logger,
+	db *sql.DB,
+	cfg config,
+) (sourceMeta, bool, error) {
+	header, err := headSource(ctx, cfg.source, cfg.fetch)
+	if err != nil {
+		l.WarnContext(
+			ctx,
+			"HEAD request failed, falling back to a full download",
+			"err", err,
+		)
+		return sourceMeta{}, false, nil
+	}
+
+	cur := sourceMeta{
+		contentLength: header.Get("Content-Length"),
+		lastModified:  header.Get("Last-Modified"),
+	}
+	if cur.empty() {
+		l.InfoContext(ctx, "source provides neither Content-Length nor Last-Modified, falling back to a full download")
+		return sourceMeta{}, false, nil
+	}
+
+	// The schema is left to the store, which reports whether it created it.
+	// Until it's up to date, there's no stored metadata to compare with.
+	var hasSchema bool
+	if err := db.QueryRowContext(ctx, sqliteHasSchemaStmt).Scan(&hasSchema); err != nil {
+		return sourceMeta{}, false, fmt.Errorf("failed to check for database schema: %w", err)
+	}
+	if !hasSchema {
+		return cur, false, nil
+	}
+	if v, err := readSchemaVersion(ctx, db); err != nil {
+		return sourceMeta{}, false, err
+	} else if v != schemaVersion {
+		return cur, false, nil
+	}
+
+	stored, err := loadSourceMeta(ctx, db)
+	if err != nil {
+		return sourceMeta{}, false, err
+	}
+
+	l.DebugContext(
+		ctx,
+		"compared source metadata",
+		"content_length", cur.contentLength,
+		"last_modified", cur.lastModified,
+		"stored_content_length", stored.contentLength,
+		"stored_last_modified", stored.lastModified,
+	)
+
+	if cur != stored {
+		return cur, false, nil
+	}
+
+	ok, err := storedCountMatches(ctx, l, db)
+	if err != nil {
+		return sourceMeta{}, false, err
+	}
+
+	return cur, ok, nil
+}
+
+// storedCountMatches reports whether the number of stored TLDs still matches the one recorded along with the source metadata.
+// If it doesn't, e.g. as rows were deleted since, skipping the download would never repair the database.
+func storedCountMatches(ctx context.Context, l *slog.Logger, db *sql.DB) (bool, error) {
+	var recorded string
+	err := db.QueryRowContext(ctx, sqliteMetaSelectStmt, metaKeyTLDCount).Scan(&recorded)
+	if errors.Is(err, sql.ErrNoRows) {
+		l.InfoContext(ctx, "no TLD count recorded yet, falling back to a full download")
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to load %q: %w", metaKeyTLDCount, err)
+	}
+
+	var count int
+	if err := db.QueryRowContext(ctx, sqliteLiveCountStmt).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to count TLDs: %w", err)
+	}
+	if strconv.Itoa(count) != recorded {
+		l.WarnContext(
+			ctx,
+			"stored TLDs changed since the source metadata was saved, falling back to a full download",
+			"count", count,
+			"recorded_count", recorded,
+		)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func loadSourceMeta(ctx context.Context, db *sql.DB) (sourceMeta, error) {
+	var m sourceMeta
+	for key, v := range map[string]*string{
+		metaKeyContentLength: &m.contentLength,
+		metaKeyLastModified:  &m.lastModified,
+	} {
+		err := db.QueryRowContext(ctx, sqliteMetaSelectStmt, key).Scan(v)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return sourceMeta{}, fmt.Errorf("failed to load %q: %w", key, err)
+		}
+	}
+
+	return m, nil
+}
+
+func saveSourceMeta(ctx context.Context, db *sql.DB, m sourceMeta) error {
+	for key, v := range map[string]string{
+		metaKeyContentLength: m.contentLength,
+		metaKeyLastModified:  m.lastModified,
+	} {
+		if _, err := db.ExecContext(ctx, sqliteMetaUpsertStmt, key, v); err != nil {
+			return fmt.Errorf("failed to save %q: %w", key, err)
+		}
+	}
+
+	// Recorded along with the metadata so that skipped runs can verify the database, see storedCountMatches
+	var count int
+	if err := db.QueryRowContext(ctx, sqliteLiveCountStmt).Scan(&count); err != nil {
+		return fmt.Errorf("failed to count TLDs: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, sqliteMetaUpsertStmt, metaKeyTLDCount, strconv.Itoa(count)); err != nil {
+		return fmt.Errorf("failed to save %q: %w", metaKeyTLDCount, err)
+	}
+
+	return nil
+}
+
+func loadListVersion(ctx context.Context, db *sql.DB) (string, error) {
+	var v string
+	err := db.QueryRowContext(ctx, sqliteMetaSelectStmt, metaKeyListVersion).Scan(&v)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("failed to load %q: %w", metaKeyListVersion, err)
+	}
+
+	return v, nil
+}
+
+func saveListVersion(ctx context.Context, db *sql.DB, v string) error {
+	if _, err := db.ExecContext(ctx, sqliteMetaUpsertStmt, metaKeyListVersion, v); err != nil {
+		return fmt.Errorf("failed to save %q: %w", metaKeyListVersion, err)
+	}
+
+	return nil
+}
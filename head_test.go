@@ -0,0 +1,101 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRunHeadCheck(t *testing.T) {
+	t.Parallel()
+
+	var gets atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			gets.Add(1)
+		}
+		http.ServeFile(w, r, fixturePath)
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := newTestConfig(srv.URL, filepath.Join(t.TempDir(), "db.sqlite"))
+	cfg.headCheck = true
+
+	if added := runToJSONConfig(t, cfg); len(added) != 42 {
+		t.Fatalf("got %d added TLDs, want 42", len(added))
+	}
+	if got, want := gets.Load(), int32(1); got != want {
+		t.Fatalf("got %d GET requests, want %d", got, want)
+	}
+
+	// The source didn't change, hence it must not be downloaded again
+	if added := runToJSONConfig(t, cfg); len(added) != 0 {
+		t.Fatalf("got %d added TLDs on second run, want none", len(added))
+	}
+	if got, want := gets.Load(), int32(1); got != want {
+		t.Fatalf("got %d GET requests, want %d", got, want)
+	}
+
+	// A database which lost rows since must be repaired rather than skipped
+	db, err := sql.Open("sqlite", cfg.sqliteFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(t.Context(), "delete from tlds where tld = 'aaa'"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if added := runToJSONConfig(t, cfg); !slices.Equal(added, []tld{"aaa"}) {
+		t.Fatalf("got added TLDs %v after deleting one, want [aaa]", added)
+	}
+	if got, want := gets.Load(), int32(2); got != want {
+		t.Fatalf("got %d GET requests after deleting a TLD, want %d", got, want)
+	}
+
+	cfg.headCheck = false
+	runToJSONConfig(t, cfg)
+	if got, want := gets.Load(), int32(3); got != want {
+		t.Fatalf("got %d GET requests without -head-check, want %d", got, want)
+	}
+}
+
+func TestRunHeadCheckInitialImport(t *testing.T) {
+	t.Parallel()
+
+	srv := newFixtureServer(t)
+
+	for _, tc := range []struct {
+		name   string
+		modify func(*config)
+	}{
+		{"sync", func(*config) {}},
+		{"swap", func(cfg *config) { cfg.atomicSwap = true }},
+		{"stream", func(cfg *config) { cfg.stream = true }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := newTestConfig(srv.URL, filepath.Join(t.TempDir(), "db.sqlite"))
+			cfg.format = formatJSONObject
+			cfg.headCheck = true
+			tc.modify(&cfg)
+
+			for i, want := range []bool{true, false} {
+				var res result
+				if err := json.Unmarshal([]byte(runToString(t, cfg)), &res); err != nil {
+					t.Fatal(err)
+				}
+				if res.InitialImport != want {
+					t.Fatalf("run %d: got initial import %t, want %t", i+1, res.InitialImport, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/net/idna"
+)
+
+const cmdLint = "lint"
+
+// Problems reported by the lint command.
+const (
+	lintProblemMalformed = "malformed"
+	lintProblemDecode    = "decode_failed"
+	lintProblemInvalid   = "invalid_label"
+	lintProblemDuplicate = "duplicate"
+)
+
+var (
+	errLintArgs     = errors.New("lint expects exactly one source file")
+	errLintProblems = errors.New("source has problems")
+)
+
+// lintProblem is a problem found in a single line of a source file.
+type lintProblem struct {
+	Line    int    `json:"line"`
+	Text    string `json:"text"`
+	Problem string `json:"problem"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// lint validates the local source file at args[0] without touching the database.
+// The file is decoded like a source, hence line numbers refer to the decoded list, e.g. to the records of a CSV file.
+// It returns an error wrapping errLintProblems if any problems were found.
+func lint(ctx context.Context, l *slog.Logger, w io.Writer, cfg config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%w, got %d", errLintArgs, len(args))
+	}
+	path := strings.TrimPrefix(args[0], fileURLPrefix)
+
+	f, err := openSourceFile(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			l.ErrorContext(ctx, fmt.Errorf("failed to close source: %w", err).Error())
+		}
+	}()
+
+	r, err := decodeSourceFile(f)
+	if err != nil {
+		return err
+	}
+	if inputFormatFor(cfg.parse.inputFormat, path, "") == inputFormatCSV {
+		if r, err = decodeCSV(r, cfg.parse.csvColumn); err != nil {
+			return err
+		}
+	}
+
+	problems, err := lintSource(r, cfg.parse)
+	if err != nil {
+		return err
+	}
+
+	if err := writeLint(w, cfg.format, problems); err != nil {
+		return fmt.Errorf("failed to print lint result: %w", err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %d found", errLintProblems, len(problems))
+	}
+
+	return nil
+}
+
+// lintSource reports all problems found in the TLDs listed in r, using the same rules as scanTLDs for comments and empty lines.
+func lintSource(r io.Reader, opts parseOptions) ([]lintProblem, error) {
+	// Unlike when parsing, labels are validated strictly
+	prof := idna.New(idna.BidiRule(), idna.ValidateLabels(true), idna.StrictDomainName(true))
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, min(opts.maxLineSize, scanBufferInitialSize)), opts.maxLineSize)
+
+	problems := make([]lintProblem, 0)
+	seen := make(map[string]int)
+
+	var n int
+	for scanner.Scan() {
+		n++
+
+		text := strings.TrimSpace(scanner.Text())
+		if opts.isComment(text) {
+			continue
+		}
+
+		for _, orig := range opts.splitLine(text) {
+			line := strings.ToLower(orig)
+
+			report := func(problem, detail string) {
+				problems = append(problems, lintProblem{
+					Line:    n,
+					Text:    orig,
+					Problem: problem,
+					Detail:  detail,
+				})
+			}
+
+			if strings.ContainsAny(line, ". \t") {
+				report(lintProblemMalformed, "a TLD is a single label")
+				continue
+			}
+
+			t, err := prof.ToUnicode(line)
+			if err != nil {
+				report(lintProblemDecode, err.Error())
+				continue
+			}
+			ascii, err := prof.ToASCII(t)
+			if err != nil {
+				report(lintProblemInvalid, err.Error())
+				continue
+			}
+			if ascii != line {
+				report(lintProblemInvalid, fmt.Sprintf("encodes to %q", ascii))
+				continue
+			}
+			if !isLDHLabel(ascii) {
+				report(lintProblemInvalid, "only letters, digits and inner hyphens are allowed")
+				continue
+			}
+
+			if first, ok := seen[t]; ok {
+				report(lintProblemDuplicate, fmt.Sprintf("first listed on line %d", first))
+				continue
+			}
+			seen[t] = n
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan source: %w", err)
+	}
+
+	return problems, nil
+}
+
+// isLDHLabel reports whether label consists of letters, digits and hyphens only, neither starting nor ending with a hyphen.
+func isLDHLabel(label string) bool {
+	if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+		return false
+	}
+	for _, r := range label {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+func writeLint(w io.Writer, format string, problems []lintProblem) error {
+	switch format {
+	case formatJSON, formatJSONObject:
+		return writeJSON(w, problems)
+	case formatHuman:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, p := range problems {
+			if _, err := fmt.Fprintf(tw, "%d\t%s\t%q\t%s\n", p.Line, p.Problem, p.Text, p.Detail); err != nil {
+				return fmt.Errorf("failed to write: %w", err)
+			}
+		}
+		if _, err := fmt.Fprintf(tw, "%d problems\n", len(problems)); err != nil {
+			return fmt.Errorf("failed to write: %w", err)
+		}
+		if err := tw.Flush(); err != nil {
+			return fmt.Errorf("failed to flush: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", errUnknownFormat, format)
+	}
+}
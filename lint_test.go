@@ -0,0 +1,65 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLintSource(t *testing.T) {
+	t.Parallel()
+
+	const source = "# Version 1\nCOM\n\ncom\nfoo.bar\nxn--zz\nXN--P1AI\nxn--p1ai\na_b\n"
+
+	problems, err := lintSource(strings.NewReader(source), parseOptions{maxLineSize: bufio.MaxScanTokenSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type lineProblem struct {
+		line    int
+		problem string
+	}
+	got := make([]lineProblem, 0, len(problems))
+	for _, p := range problems {
+		got = append(got, lineProblem{p.Line, p.Problem})
+	}
+	want := []lineProblem{
+		{4, lintProblemDuplicate},
+		{5, lintProblemMalformed},
+		{6, lintProblemDecode},
+		{8, lintProblemDuplicate},
+		{9, lintProblemInvalid},
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got problems %v, want %v", got, want)
+	}
+}
+
+func TestLint(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestConfig("", "")
+
+	var buf bytes.Buffer
+	if err := lint(t.Context(), newTestLogger(), &buf, cfg, []string{fixturePath}); err != nil {
+		t.Fatalf("got error %v linting the fixture, want none", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "tlds.txt")
+	if err := os.WriteFile(path, []byte("com\ncom\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := lint(t.Context(), newTestLogger(), &buf, cfg, []string{path}); !errors.Is(err, errLintProblems) {
+		t.Fatalf("got error %v, want %v", err, errLintProblems)
+	}
+
+	if err := lint(t.Context(), newTestLogger(), &buf, cfg, nil); !errors.Is(err, errLintArgs) {
+		t.Fatalf("got error %v, want %v", err, errLintArgs)
+	}
+}
@@ -0,0 +1,9 @@
+package main
+
+import (
+	"errors"
+)
+
+const lockFileSuffix = ".lock"
+
+var errLocked = errors.New("another run is in progress")
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// acquireLock is a no-op on platforms without flock support.
+func acquireLock(_ context.Context, _ string, _ time.Duration) (func() error, error) {
+	return func() error { return nil }, nil
+}
@@ -0,0 +1,58 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	lockFilePerm     = 0o600
+	lockPollInterval = 100 * time.Millisecond
+)
+
+// acquireLock takes an exclusive advisory lock on the file at path, creating it if needed.
+// It keeps trying for up to timeout and returns errLocked if the lock is still held by someone else.
+// The returned function releases the lock.
+func acquireLock(ctx context.Context, path string, timeout time.Duration) (func() error, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, lockFilePerm) //nolint:gosec // The path is provided by the operator
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB) //nolint:gosec // File descriptors fit into an int
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errors.Join(
+				fmt.Errorf("failed to lock %q: %w", path, err),
+				f.Close(),
+			)
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.Join(
+				fmt.Errorf("%w: %q is locked", errLocked, path),
+				f.Close(),
+			)
+		}
+		if err := sleepContext(ctx, lockPollInterval); err != nil {
+			return nil, errors.Join(err, f.Close())
+		}
+	}
+
+	return func() error {
+		// Closing the file releases the lock
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to release lock: %w", err)
+		}
+		return nil
+	}, nil
+}
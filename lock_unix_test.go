@@ -0,0 +1,36 @@
+//go:build unix
+
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestAcquireLock(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "db.sqlite"+lockFileSuffix)
+
+	unlock, err := acquireLock(t.Context(), path, 0)
+	if err != nil {
+		t.Fatalf("failed to acquire lock: %v", err)
+	}
+
+	if _, err := acquireLock(t.Context(), path, 2*lockPollInterval); !errors.Is(err, errLocked) {
+		t.Fatalf("got error %v, want %v", err, errLocked)
+	}
+
+	if err := unlock(); err != nil {
+		t.Fatalf("failed to release lock: %v", err)
+	}
+
+	unlock, err = acquireLock(t.Context(), path, 0)
+	if err != nil {
+		t.Fatalf("failed to acquire lock after release: %v", err)
+	}
+	if err := unlock(); err != nil {
+		t.Fatalf("failed to release lock: %v", err)
+	}
+}
@@ -3,18 +3,20 @@ package main
 import (
 	"bufio"
 	"context"
-	"database/sql"
-	"encoding/json"
+	"errors"
 	"flag" //nolint:depguard // We only allow to import the flag package in here
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
-	"golang.org/x/net/idna"
-
+	"golang.org/x/net/http/httpguts"
 	_ "modernc.org/sqlite"
 )
 
@@ -27,6 +29,14 @@ const (
 const (
 	defaultSQLiteFilePath = "./db.sqlite"
 
+	defaultDBDirPerm = 0o755
+
+	// defaultMaxResponseBytes is ample for the IANA list, which is about 10 KB
+	defaultMaxResponseBytes = 5 << 20
+
+	defaultDBOpenRetries    = 3
+	defaultDBOpenRetryDelay = 500 * time.Millisecond
+
 	sqliteInitStmt = `
 		begin;
 		create table tlds (
@@ -34,9 +44,136 @@ const (
 		) strict;
 		commit;
 	`
+	sqliteMetaCreateStmt = `
+		create table meta (
+			key text primary key not null,
+			value text not null
+		) strict;
+	`
+	sqliteMetaSelectStmt = `
+		select value from meta where key = ?;
+	`
+	sqliteMetaUpsertStmt = `
+		insert into meta (key, value) values (?, ?) on conflict (key) do update set value = excluded.value;
+	`
+	sqliteLiveCountStmt = `
+		select count(*) from tlds where removed_at is null;
+	`
+	sqliteHasSchemaStmt = `
+		select count(*) > 0 from sqlite_master where type = 'table' and name = 'tlds';
+	`
+	sqliteOrdinalAddStmt = `
+		alter table tlds add column ordinal integer;
+	`
+	sqliteSourceAddStmt = `
+		alter table tlds add column source text;
+	`
+	sqliteRemovedAtAddStmt = `
+		alter table tlds add column removed_at text;
+	`
+	sqliteSourceRenameStmt = `
+		alter table tlds rename column source to source_url;
+	`
+	// TLDs stored already were listed by the last run, which is approximated by the time of the migration.
+	// Removed ones were listed before they were marked as such at the latest.
+	sqliteLastSeenAddStmt = `
+		alter table tlds add column last_seen text;
+		update tlds set last_seen = coalesce(removed_at, strftime('%Y-%m-%dT%H:%M:%SZ', 'now'));
+	`
+	// Overrides in effect when TLDs were stored are unknown, hence they're classified by their form only.
+	sqliteKindAddStmt = `
+		alter table tlds add column kind text;
+		alter table tlds add column kind_overridden integer;
+		update tlds set kind = case when tld glob '[a-z][a-z]' then 'cc' else 'generic' end, kind_overridden = 0;
+	`
 	sqliteInsertStmt = `
-		insert into tlds (tld) values (?);
+		insert into tlds (tld, ordinal, source_url, kind, kind_overridden) values (?, ?, ?, ?, ?);
+	`
+	sqliteReplaceStmt = `
+		insert into tlds (tld, ordinal, source_url, kind, kind_overridden) values (?, ?, ?, ?, ?) on conflict (tld) do update set ordinal = excluded.ordinal, removed_at = null, kind = excluded.kind, kind_overridden = excluded.kind_overridden;
+	`
+	sqliteUpsertStmt = `
+		insert into tlds (tld, ordinal, source_url, kind, kind_overridden) values (?, ?, ?, ?, ?) on conflict (tld) do update set ordinal = excluded.ordinal, kind = excluded.kind, kind_overridden = excluded.kind_overridden;
+	`
+	// Restores TLDs marked as removed, affecting no row if the TLD is stored already otherwise
+	sqliteRestoreInsertStmt = `
+		insert into tlds (tld, ordinal, source_url, kind, kind_overridden) values (?, ?, ?, ?, ?) on conflict (tld) do update set removed_at = null where removed_at is not null;
+	`
+	sqliteDeleteStmt = `
+		delete from tlds where tld = ?;
+	`
+	sqliteExistsStmt = `
+		select count(*) > 0 from tlds where tld = ?;
+	`
+	sqliteSelectStmt = `
+		select tld from tlds order by tld;
+	`
+	sqliteLiveExistsStmt = `
+		select count(*) > 0 from tlds where tld = ? and removed_at is null;
+	`
+	sqliteLiveSelectStmt = `
+		select tld from tlds where removed_at is null order by tld;
+	`
+	sqliteRemovedSelectStmt = `
+		select tld from tlds where removed_at is not null order by tld;
+	`
+	sqliteRemovedSetStmt = `
+		update tlds set removed_at = ? where tld = ?;
 	`
+	sqliteLastSeenSetStmt = `
+		update tlds set last_seen = ? where tld = ?;
+	`
+
+	sqliteSwapCreateStmt = `
+		create table tlds_new (
+			tld text primary key not null,
+			ordinal integer,
+			source_url text,
+			removed_at text,
+			last_seen text,
+			kind text,
+			kind_overridden integer
+		) strict;
+	`
+	sqliteSwapInsertStmt = `
+		insert or ignore into tlds_new (tld, ordinal, source_url, last_seen, kind, kind_overridden) values (?1, ?2, coalesce((select source_url from tlds where tld = ?1), ?3), ?4, ?5, ?6);
+	`
+	sqliteSwapKeepStmt = `
+		insert or ignore into tlds_new (tld, ordinal, source_url, removed_at, last_seen, kind, kind_overridden)
+		select tld, ordinal, source_url, removed_at, last_seen, kind, kind_overridden from tlds where tld = ?;
+	`
+	sqliteSwapAddedStmt = `
+		select tld from tlds_new where tld not in (select tld from tlds where removed_at is null) order by rowid;
+	`
+	sqliteSwapRemovedStmt = `
+		select tld from tlds where removed_at is null and tld not in (select tld from tlds_new) order by tld;
+	`
+	sqliteSwapStmt = `
+		drop table tlds;
+		alter table tlds_new rename to tlds;
+	`
+)
+
+const (
+	exitCodeOK = iota
+	exitCodeError
+	// exitCodeLocked signals that another run still holds the lock
+	exitCodeLocked
+	// exitCodeSource signals that the local source file can't be read
+	exitCodeSource
+	// exitCodeRemoved signals that TLDs were removed, see -print-removed-only
+	exitCodeRemoved
+	// exitCodeDiffer signals that the compared sources differ
+	exitCodeDiffer
+)
+
+var (
+	errInvalidHeader      = errors.New("invalid header")
+	errEmptyCommentPrefix = errors.New("comment prefix must not be empty")
+	errInvalidFileMode    = errors.New("invalid file mode")
+	errInvalidKind        = errors.New("invalid kind override")
+	errUnknownCommand     = errors.New("unknown command")
+	errTLDsRemoved        = errors.New("TLDs were removed")
 )
 
 //nolint:gochecknoglobals // Nice to use as a global
@@ -44,128 +181,515 @@ var logTarget = os.Stderr
 
 type tld string
 
-func loadTLDs(ctx context.Context, requestTimeout time.Duration, l *slog.Logger) ([]tld, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tldURL, http.NoBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+// result describes the outcome of a run.
+type result struct {
+	// InitialImport is set if the database was initialized by the run.
+	// In that case every TLD is reported as added.
+	InitialImport bool `json:"initial_import"`
+	// RunLabel is the label of the run, see -run-label
+	RunLabel string `json:"run_label,omitempty"`
+	Added    []tld  `json:"added"`
+	// Removed lists the stored TLDs which went missing from the source since the last run
+	Removed []tld `json:"removed"`
+	// Changed lists the TLDs whose Unicode form changed, see extractChanged
+	Changed []tldChange `json:"changed,omitempty"`
+	// Warnings summarizes the issues found while parsing the source, if any
+	Warnings *parseWarnings `json:"warnings,omitempty"`
+
+	// removedOnly limits the output to the removed TLDs
+	removedOnly bool
+	// total is the number of TLDs in the source
+	total int
+	// version is the version of the list stated by the source, empty if unknown
+	version string
+	// outputKey, if set, makes the json format emit objects holding the TLD under this key
+	outputKey string
+	// template is executed by the template format
+	template *template.Template
+
+	// display optionally maps TLDs to the form they should be presented in
+	display map[tld]string
+}
+
+type config struct {
+	source     string
+	sqliteFile string
+	format     string
+	outputs    []outputTarget
+	runLabel   string
+
+	noStdoutOnNoChange bool
+	onlyInvalid        bool
+	printRemovedOnly   bool
+	outputKey          string
+	template           *template.Template
+
+	fetch fetchOptions
+	parse parseOptions
+
+	changelogFile   string
+	timezone        *time.Location
+	statusFile      string
+	metricsTextfile string
+	statsdAddr      string
+	natsAddr        string
+	natsSubject     string
+
+	dbOpenRetries    int
+	dbOpenRetryDelay time.Duration
+
+	atomicSwap      bool
+	stream          bool
+	headCheck       bool
+	progress        bool
+	reconcileDelete bool
+	seed            bool
+	conflict        string
+	failFast        bool
+
+	// The following only affect how realMain sets up the run or which command it runs, they're kept for print-config
+	debug           bool
+	pidFile         string
+	expandEnv       bool
+	acceptFormats   []string
+	mkdir           bool
+	mkdirPerm       os.FileMode
+	noLock          bool
+	lockTimeout     time.Duration
+	explainTLD      string
+	benchSize       int
+	generatePackage string
+}
+
+// headerFlag collects repeated "Key: Value" flags into an http.Header.
+type headerFlag http.Header
+
+func (h headerFlag) String() string {
+	var b strings.Builder
+	if err := http.Header(h).Write(&b); err != nil {
+		return ""
 	}
+	return strings.TrimSpace(b.String())
+}
 
-	res, err := (&http.Client{
-		Timeout: requestTimeout,
-	}).Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get: %w", err)
+func (h headerFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, ":")
+	k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+	if !ok || !httpguts.ValidHeaderFieldName(k) || !httpguts.ValidHeaderFieldValue(v) {
+		return fmt.Errorf("%w: %q, want \"Key: Value\"", errInvalidHeader, s)
 	}
-	defer func() {
-		if err := res.Body.Close(); err != nil {
-			l.ErrorContext(ctx, fmt.Errorf("failed to close body: %w", err).Error())
-		}
-	}()
 
-	prof := idna.New(idna.BidiRule())
+	http.Header(h).Add(k, v)
+	return nil
+}
 
-	var tlds []tld
-	for scanner := bufio.NewScanner(res.Body); scanner.Scan(); {
-		line := strings.ToLower(strings.TrimSpace(scanner.Text()))
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
+// commentPrefixFlag collects repeated comment prefixes.
+type commentPrefixFlag []string
 
-		t, err := prof.ToUnicode(line)
-		if err != nil {
-			l.ErrorContext(ctx, fmt.Errorf("failed to puny decode %q: %w", line, err).Error())
-		}
+func (c *commentPrefixFlag) String() string {
+	return strings.Join(*c, ",")
+}
+
+func (c *commentPrefixFlag) Set(s string) error {
+	if s == "" {
+		return errEmptyCommentPrefix
+	}
+
+	*c = append(*c, s)
+	return nil
+}
+
+// kindOverrideFlag collects repeated "tld=kind" flags.
+type kindOverrideFlag map[string]string
+
+func (k kindOverrideFlag) String() string {
+	ss := make([]string, 0, len(k))
+	for t, kind := range k {
+		ss = append(ss, t+"="+kind)
+	}
+	slices.Sort(ss)
+	return strings.Join(ss, ",")
+}
+
+func (k kindOverrideFlag) Set(s string) error {
+	t, kind, ok := strings.Cut(s, "=")
+	t = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(t), "."))
+	kind = strings.TrimSpace(kind)
+	if !ok || t == "" || (kind != kindCC && kind != kindGeneric) {
+		return fmt.Errorf("%w: %q, want \"tld=cc\" or \"tld=generic\"", errInvalidKind, s)
+	}
+
+	k[t] = kind
+	return nil
+}
+
+// fileModeFlag is a permission mode given in octal, e.g. "0750".
+type fileModeFlag os.FileMode
+
+func (m *fileModeFlag) String() string {
+	return fmt.Sprintf("%#o", os.FileMode(*m))
+}
+
+func (m *fileModeFlag) Set(s string) error {
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || os.FileMode(v)&^os.ModePerm != 0 {
+		return fmt.Errorf("%w: %q, want permission bits in octal", errInvalidFileMode, s)
+	}
 
-		tlds = append(tlds, tld(t))
+	*m = fileModeFlag(v)
+	return nil
+}
+
+// outputFlag collects repeated "path[:format]" flags into output targets.
+type outputFlag []outputTarget
+
+func (o *outputFlag) String() string {
+	ss := make([]string, 0, len(*o))
+	for _, t := range *o {
+		ss = append(ss, t.String())
 	}
+	return strings.Join(ss, ",")
+}
 
-	return tlds, nil
+func (o *outputFlag) Set(s string) error {
+	t, err := parseOutputTarget(s)
+	if err != nil {
+		return err
+	}
+
+	*o = append(*o, t)
+	return nil
+}
+
+// outputTargets returns the configured output targets, stdout by default.
+func (c config) outputTargets() []outputTarget {
+	if len(c.outputs) == 0 {
+		return []outputTarget{{path: stdoutPath}}
+	}
+	return c.outputs
 }
 
+// run performs a single sync and writes the status file and metrics textfile, if configured.
 func run(
 	ctx context.Context,
 	l *slog.Logger,
-	sqliteFile string,
+	w io.Writer,
+	cfg config,
+) error {
+	var st runStatus
+	err := runOnce(ctx, l, w, cfg, &st)
+
+	// Removals are only reported through an error
+	st.Success = err == nil || errors.Is(err, errTLDsRemoved)
+	st.At = time.Now().UTC()
+	if !st.Success {
+		st.Error, st.ErrorCategory = err.Error(), st.stage
+		l.InfoContext(
+			ctx,
+			"run failed",
+			"category", st.ErrorCategory,
+			"added", st.Added,
+		)
+	}
+
+	if cfg.statusFile != "" {
+		if err := writeStatusFile(cfg.statusFile, st); err != nil {
+			l.ErrorContext(ctx, err.Error())
+		}
+	}
+	if cfg.metricsTextfile != "" {
+		if err := writeMetricsTextfile(cfg.metricsTextfile, st, cfg.runLabel); err != nil {
+			l.ErrorContext(ctx, err.Error())
+		}
+	}
+
+	return err
+}
+
+// runOnce performs a single sync, recording the number of changes in st.
+func runOnce(
+	ctx context.Context,
+	l *slog.Logger,
+	w io.Writer,
+	cfg config,
+	st *runStatus,
 ) error {
 	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
 	defer cancel()
 
-	tlds, err := loadTLDs(ctx, requestTimeout, l)
+	st.stage = runStageDatabase
+	db, err := openDB(ctx, l, cfg.sqliteFile, cfg.dbOpenRetries, cfg.dbOpenRetryDelay)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			l.ErrorContext(ctx, fmt.Errorf("failed to close database: %w", err).Error())
+		}
+	}()
 
-	var isFirstRun bool
-	if _, err := os.Stat(sqliteFile); os.IsNotExist(err) {
-		isFirstRun = true
+	var (
+		meta      sourceMeta
+		unchanged bool
+	)
+	if cfg.headCheck && isRemoteSource(cfg.source) {
+		if meta, unchanged, err = sourceUnchanged(ctx, l, db, cfg); err != nil {
+			return err
+		}
 	}
 
-	db, err := sql.Open("sqlite", sqliteFile)
-	if err != nil {
-		return fmt.Errorf("failed to open sqlite database: %w", err)
+	var (
+		res           result
+		fetchDuration time.Duration
+	)
+	fetchStart := time.Now()
+	switch {
+	case unchanged:
+		l.InfoContext(ctx, "source is unchanged according to a HEAD request, skipping the download")
+		res = result{
+			Added:   make([]tld, 0),
+			Removed: make([]tld, 0),
+		}
+		if err := db.QueryRowContext(ctx, sqliteLiveCountStmt).Scan(&res.total); err != nil {
+			return fmt.Errorf("failed to count TLDs: %w", err)
+		}
+		// The list wasn't read, its version is the one stored by the last run
+		if res.version, err = loadListVersion(ctx, db); err != nil {
+			return err
+		}
+	case cfg.stream:
+		// When streaming, the source is loaded while storing
+		st.stage = runStageStore
+		if res, err = streamWithDB(ctx, l, db, cfg); err != nil {
+			st.Added = len(res.Added)
+			return err
+		}
+		fetchDuration = time.Since(fetchStart)
+	default:
+		st.stage = runStageSource
+		parseOpts := cfg.parse
+		parseOpts.warnings = new(parseWarnings)
+		var version string
+		parseOpts.version = &version
+		tlds, display, err := loadTLDs(ctx, requestTimeout, l, cfg.source, cfg.fetch, parseOpts)
+		if err != nil {
+			return err
+		}
+		fetchDuration = time.Since(fetchStart)
+
+		store := syncWithDB
+		if cfg.atomicSwap {
+			store = swapWithDB
+		}
+
+		opts := storeOptions{
+			ordinals: hasOrdinals(cfg),
+			source:   cfg.source,
+			conflict: cfg.conflict,
+			failFast: cfg.failFast,
+			parse:    cfg.parse,
+		}
+		// Progress lines would only clutter logs
+		if cfg.progress && isTerminal(os.Stderr) {
+			opts.prog = newProgress(os.Stderr, len(tlds))
+		}
+
+		st.stage = runStageStore
+		res, err = store(ctx, l, db, tlds, opts)
+		// Stored changes are recorded even if a later stage fails
+		st.Added, st.Removed = len(res.Added), len(res.Removed)
+		if err != nil {
+			return err
+		}
+		res.total = len(tlds)
+		res.version = version
+		res.display = display
+		res.extractChanged()
+		if !parseOpts.warnings.empty() {
+			res.Warnings = parseOpts.warnings
+		}
 	}
 
-	if isFirstRun {
-		if _, err := db.Exec(sqliteInitStmt); err != nil {
-			return fmt.Errorf("failed to init database: %w", err)
+	st.stage = runStageReport
+	if !unchanged && !meta.empty() {
+		if err := saveSourceMeta(ctx, db, meta); err != nil {
+			return err
+		}
+	}
+	if res.version != "" {
+		if err := saveListVersion(ctx, db, res.version); err != nil {
+			return err
 		}
-		l.InfoContext(ctx, "successfully initialized database")
 	}
 
-	stmt, err := db.Prepare(sqliteInsertStmt)
-	if err != nil {
-		return fmt.Errorf("failed to prepare insert statement: %w", err)
+	res.RunLabel = cfg.runLabel
+	res.outputKey = cfg.outputKey
+	res.template = cfg.template
+
+	// Seeding initializes the database from a trusted source, it's not a change to report
+	if cfg.seed {
+		l.InfoContext(
+			ctx,
+			"seeded database",
+			"count", len(res.Added),
+		)
+		res.Added = make([]tld, 0)
 	}
-	defer func() {
-		if err := stmt.Close(); err != nil {
-			l.ErrorContext(ctx, fmt.Errorf("failed to close insert statement: %w", err).Error())
+	st.Added, st.Removed = len(res.Added), len(res.Removed)
+	st.total, st.fetchDuration = res.total, fetchDuration
+	st.Version = res.version
+
+	if cfg.statsdAddr != "" {
+		if err := sendStatsD(ctx, cfg.statsdAddr, runMetrics{
+			total:         res.total,
+			added:         len(res.Added),
+			removed:       len(res.Removed),
+			fetchDuration: fetchDuration,
+			runLabel:      cfg.runLabel,
+		}); err != nil {
+			l.ErrorContext(ctx, err.Error())
 		}
-	}()
+	}
 
-	newTLDs := make([]tld, 0, len(tlds))
-	for _, tld := range tlds {
-		if _, err := stmt.ExecContext(
-			context.WithoutCancel(ctx),
-			tld,
-		); err != nil {
-			// TODO: Properly check for error, see https://gitlab.com/cznic/sqlite/-/blob/f49aba7eddcec7d31797e72c67aafb0398970730/all_test.go#L2228
-			if got, want := err.Error(), "constraint failed: UNIQUE constraint failed: tlds.tld (1555)"; got == want {
-				// This is fine
-				continue
-			}
+	// Subscribers are notified of the full result once it's persisted, i.e. after printing it
+	published := res
+
+	if cfg.printRemovedOnly {
+		res.Added = make([]tld, 0)
+		res.Changed = nil
+		res.removedOnly = true
+	}
 
-			l.ErrorContext(
-				ctx,
-				"failed to exec insert statement",
-				"err", err,
-				"tld", fmt.Sprintf("%+v", tld),
-			)
-			continue
+	targets := slices.Clone(cfg.outputTargets())
+	if len(res.Added) == 0 && len(res.Removed) == 0 && len(res.Changed) == 0 {
+		l.InfoContext(ctx, "no changes")
+
+		// Keep cron mails quiet
+		if cfg.noStdoutOnNoChange {
+			targets = slices.DeleteFunc(targets, outputTarget.isStdout)
 		}
+	}
 
-		newTLDs = append(newTLDs, tld)
+	if err := writeOutputs(w, targets, cfg.format, res); err != nil {
+		return fmt.Errorf("failed to print result: %w", err)
 	}
 
-	// Print as JSON
-	if err := json.NewEncoder(os.Stdout).Encode(newTLDs); err != nil {
-		return fmt.Errorf("failed to JSON-print to stdout: %w", err)
+	// Appended only once the outputs are written, a failure must not lose them
+	if cfg.changelogFile != "" {
+		if err := appendChangelog(cfg.changelogFile, time.Now(), cfg.timezone, published.Added, published.Removed); err != nil {
+			return err
+		}
+	}
+
+	if cfg.natsAddr != "" && (len(published.Added) > 0 || len(published.Removed) > 0 || len(published.Changed) > 0) {
+		if err := publishNATS(ctx, cfg.natsAddr, cfg.natsSubject, published); err != nil {
+			l.ErrorContext(ctx, err.Error())
+		}
+	}
+
+	if cfg.printRemovedOnly && len(res.Removed) > 0 {
+		return fmt.Errorf("%w: %d", errTLDsRemoved, len(res.Removed))
 	}
 
 	return nil
 }
 
 func main() {
+	os.Exit(realMain())
+}
+
+func realMain() int {
 	debug := flag.Bool("debug", false, "enable debug mode")
+	source := flag.String("source", tldURL, "URL or local file path to load the TLD list from")
+	runLabel := flag.String("run-label", "", "label attached to the result, metrics and logs of this run, e.g. the environment")
+	pidFile := flag.String("pidfile", "", "write the process ID to this file while running")
+	expandEnv := flag.Bool("expand-env", false, "expand $VAR references in file paths, including SQLITE_FILE")
+	format := flag.String("format", formatJSON, "output format, one of: json, json-object, human, template, patch")
+	outputTemplate := flag.String("template", "", "template format: Go text/template executed once with the result, e.g. '{{range .Added}}{{println .}}{{end}}'")
+	outputKey := flag.String("output-key", "", "json format: emit objects holding the TLD under this key instead of bare strings")
+	var outputs outputFlag
+	flag.Var(&outputs, "out", "write the result to this file, \"-\" for stdout, optionally in another format using \"path:format\", may be repeated")
+	noStdoutOnNoChange := flag.Bool("no-stdout-on-nochange", false, "don't print anything to stdout if no TLDs were added, removed or changed")
+	ipVersion := flag.String("ip-version", ipVersionAuto, "IP version to fetch the source over, one of: auto, 4, 6")
+	printRemovedOnly := flag.Bool("print-removed-only", false, "only report TLDs missing from the source, exiting with 4 if there are any")
+	benchSize := flag.Int("bench-size", defaultBenchSize, "bench: number of TLDs in the generated source")
+	generatePackage := flag.String("generate-package", defaultGeneratePackage, "generate: package of the generated Go file")
+	onlyInvalid := flag.Bool("only-invalid", false, "check-all: only report domains with an unknown TLD")
+	resumeRetries := flag.Int("resume-retries", 0, "resume a broken-off download of the source up to this many times using range requests, buffering it in a temporary file")
+	verifyChecksum := flag.Bool("verify-checksum", false, "verify a remote source against the checksum file published next to it, trying the .sha256 and .md5 extensions, before parsing it")
+	maxResponseBytes := flag.Int64("max-response-bytes", defaultMaxResponseBytes, "abort if the response body of the source exceeds this many bytes, 0 to disable")
+	unixSocket := flag.String("unix-socket", "", "fetch the source through this Unix domain socket instead of connecting to its host, e.g. for a local sidecar proxy")
+	strictHTTPS := flag.Bool("strict-https", false, "refuse to fetch the source over plain HTTP, local files are still allowed")
+	http2 := flag.Bool("http2", true, "allow fetching the source over HTTP/2")
+	idleTimeout := flag.Duration("idle-conn-timeout", idleConnTimeout, "how long idle connections to the source are kept open for reuse")
+	keepAlive := flag.Duration("tcp-keep-alive", dialKeepAlive, "interval of TCP keep-alive probes on connections to the source, negative to disable")
+	saveRawPath := flag.String("save-raw", "", "keep a copy of the raw source at this path, {timestamp} is replaced by the current time")
+	header := make(headerFlag)
+	flag.Var(header, "header", "add a \"Key: Value\" header to the source request, may be repeated")
+	inputFormat := flag.String("format-in", inputFormatAuto, "source format, one of: auto, text, csv")
+	acceptFormats := flag.String("accept-formats", "", "comma-separated source formats to request via the Accept header in order of preference, e.g. \"text,csv\", the response is then parsed according to its Content-Type unless -format-in is set")
+	csvColumn := flag.String("csv-column", defaultCSVColumn, "name or zero-based index of the CSV column holding the TLD")
+	maxLineSize := flag.Int("max-line-size", bufio.MaxScanTokenSize, "maximum size in bytes of a single line in the source")
+	minTLDLength := flag.Int("min-tld-length", 0, "skip TLDs shorter than this many characters, 0 to disable")
+	maxTLDLength := flag.Int("max-tld-length", 0, "skip TLDs longer than this many characters, 0 to disable")
+	onlyCC := flag.Bool("only-cc", false, "only keep two-letter country-code TLDs")
+	onlyGeneric := flag.Bool("only-generic", false, "skip two-letter country-code TLDs")
+	kindOverrides := make(kindOverrideFlag)
+	flag.Var(kindOverrides, "kind-override", "classify a TLD as \"cc\" or \"generic\" for -only-cc and -only-generic regardless of its length, e.g. \"рф=cc\" (repeatable)")
+	idnaVerify := flag.Bool("idna-verify", false, "warn about TLDs which don't encode back to their original ASCII form")
+	idnaStrict := flag.Bool("idna-strict", false, "like -idna-verify, but also skip such TLDs")
+	multiPerLine := flag.Bool("multi-per-line", false, "split lines of the source on whitespace and commas, for sources listing several TLDs per line")
+	noIDNA := flag.Bool("no-idna", false, "store TLDs in their ASCII form as listed in the source instead of decoding punycode")
+	var commentPrefixes commentPrefixFlag
+	flag.Var(&commentPrefixes, "comment-prefix", "skip source lines starting with this prefix instead of \"#\", may be repeated")
+	preserveCase := flag.Bool("preserve-case", false, "print TLDs in the casing used by the source instead of lowercase")
+	timezone := flag.String("timezone", "UTC", "time zone of the timestamps in the changelog file, \"Local\" or an IANA name like \"Europe/Berlin\"")
+	statusFile := flag.String("status-file", "", "atomically write the outcome of the run as JSON to this file")
+	metricsTextfile := flag.String("metrics-textfile", "", "atomically write Prometheus metrics of the run to this file, e.g. for the node_exporter textfile collector")
+	changelogFile := flag.String("changelog-file", "", "append the TLDs added by this run to this file")
+	atomicSwap := flag.Bool("atomic-swap", false, "replace the stored TLDs by the fetched ones in a single transaction, dropping TLDs no longer listed")
+	stream := flag.Bool("stream", false, "store TLDs while parsing the source instead of loading it into memory first, for very large sources")
+	headCheck := flag.Bool("head-check", false, "skip the download if a HEAD request reports the same Content-Length and Last-Modified as on the last run")
+	conflict := flag.String("conflict", conflictIgnore, "how to handle TLDs which are stored already, one of: ignore, replace, update")
+	failFast := flag.Bool("fail-fast", false, "abort and roll back the run on the first unexpected insert error instead of logging it")
+	showProgress := flag.Bool("progress", false, "print the progress of storing TLDs to stderr if it's a terminal")
+	reconcileDelete := flag.Bool("reconcile-delete", false, "reconcile: also delete stored TLDs missing from the source")
+	explainTLD := flag.String("explain", "", "instead of storing anything, trace this TLD through fetching and parsing and report whether it's stored")
+	seed := flag.Bool("seed", false, "store the fetched TLDs without reporting them as added")
+	dbOpenRetries := flag.Int("db-open-retries", defaultDBOpenRetries, "number of times to retry reaching the database")
+	dbOpenRetryDelay := flag.Duration("db-open-retry-delay", defaultDBOpenRetryDelay, "delay between attempts to reach the database")
+	mkdir := flag.Bool("mkdir", false, "create the directory holding SQLITE_FILE if it doesn't exist")
+	mkdirPerm := fileModeFlag(defaultDBDirPerm)
+	flag.Var(&mkdirPerm, "mkdir-perm", "permissions of the directories created by -mkdir, in octal")
+	noLock := flag.Bool("no-lock", false, "don't lock the database against overlapping runs")
+	lockTimeout := flag.Duration("lock-timeout", 0, "how long to wait for an overlapping run to finish")
 
 	flag.Parse()
 
 	sqliteFile := getenv("SQLITE_FILE", defaultSQLiteFilePath)
+	statsdAddr := getenv("TLD_STATSD_ADDR", "")
+	natsAddr := getenv("TLD_NATS_ADDR", "")
+	natsSubject := getenv("TLD_NATS_SUBJECT", defaultNATSSubject)
+
+	if *expandEnv {
+		for i := range outputs {
+			expandEnvPaths(&outputs[i].path)
+		}
+		expandEnvPaths(&sqliteFile, pidFile, saveRawPath, changelogFile, statusFile, metricsTextfile, unixSocket)
+		// URLs may legitimately contain a "$"
+		if !isRemoteSource(*source) {
+			expandEnvPaths(source)
+		}
+	}
 
 	ll := new(slog.LevelVar)
 	ll.Set(slog.LevelInfo)
 	l := slog.New(slog.NewJSONHandler(logTarget, &slog.HandlerOptions{
 		Level: ll,
 	}))
+	if *runLabel != "" {
+		l = l.With("run_label", *runLabel)
+	}
 	slog.SetDefault(l)
 
 	// We have a debug env var as well as a debug CLI flag
@@ -179,11 +703,262 @@ func main() {
 
 	ctx := context.Background()
 
-	if err := run(
-		ctx,
-		l,
-		sqliteFile,
-	); err != nil {
+	if err := validateFormat(*format); err != nil {
+		l.ErrorContext(ctx, err.Error())
+		return exitCodeError
+	}
+	if err := validateRunLabel(*runLabel, statsdAddr); err != nil {
+		l.ErrorContext(ctx, err.Error())
+		return exitCodeError
+	}
+	if err := validateOutputs(outputs); err != nil {
+		l.ErrorContext(ctx, err.Error())
+		return exitCodeError
+	}
+	tmpl, err := parseOutputTemplate(*outputTemplate)
+	if err != nil {
+		l.ErrorContext(ctx, err.Error())
+		return exitCodeError
+	}
+	if err := validateTemplate(*format, outputs, tmpl); err != nil {
+		l.ErrorContext(ctx, err.Error())
+		return exitCodeError
+	}
+	if err := validateSourceScheme(*source, *strictHTTPS); err != nil {
+		l.ErrorContext(ctx, err.Error())
+		return exitCodeError
+	}
+	if err := validateIPVersion(*ipVersion); err != nil {
+		l.ErrorContext(ctx, err.Error())
+		return exitCodeError
+	}
+	if err := validateInputFormat(*inputFormat); err != nil {
+		l.ErrorContext(ctx, err.Error())
+		return exitCodeError
+	}
+	var accepted []string
+	if *acceptFormats != "" {
+		accepted = strings.Split(*acceptFormats, ",")
+		accept, err := acceptHeader(accepted)
+		if err != nil {
+			l.ErrorContext(ctx, err.Error())
+			return exitCodeError
+		}
+		// An explicit -header takes precedence
+		if http.Header(header).Get("Accept") == "" {
+			http.Header(header).Set("Accept", accept)
+		}
+	}
+	if *maxLineSize <= 0 {
+		l.ErrorContext(ctx, "max-line-size must be positive")
+		return exitCodeError
+	}
+	if *minTLDLength > 0 && *maxTLDLength > 0 && *minTLDLength > *maxTLDLength {
+		l.ErrorContext(ctx, "min-tld-length must not exceed max-tld-length")
+		return exitCodeError
+	}
+	if err := validateConflictPolicy(*conflict); err != nil {
+		l.ErrorContext(ctx, err.Error())
+		return exitCodeError
+	}
+	if *conflict != conflictIgnore && (*stream || *atomicSwap) {
+		l.ErrorContext(ctx, "conflict can't be combined with stream or atomic-swap")
+		return exitCodeError
+	}
+	if *stream && (*atomicSwap || *printRemovedOnly || *verifyChecksum) {
+		l.ErrorContext(ctx, "stream can't be combined with atomic-swap, print-removed-only or verify-checksum")
+		return exitCodeError
+	}
+	loc, err := time.LoadLocation(*timezone)
+	if err != nil {
+		l.ErrorContext(ctx, fmt.Errorf("failed to load time zone: %w", err).Error())
+		return exitCodeError
+	}
+	if *onlyCC && *onlyGeneric {
+		l.ErrorContext(ctx, "only-cc and only-generic are mutually exclusive")
+		return exitCodeError
+	}
+	if natsAddr != "" {
+		if _, err := parseNATSAddr(natsAddr); err != nil {
+			l.ErrorContext(ctx, err.Error())
+			return exitCodeError
+		}
+		if err := validateNATSSubject(natsSubject); err != nil {
+			l.ErrorContext(ctx, err.Error())
+			return exitCodeError
+		}
+	}
+
+	cfg := config{
+		source:     *source,
+		sqliteFile: sqliteFile,
+		format:     *format,
+		outputs:    outputs,
+		runLabel:   *runLabel,
+
+		noStdoutOnNoChange: *noStdoutOnNoChange,
+		onlyInvalid:        *onlyInvalid,
+		printRemovedOnly:   *printRemovedOnly,
+		outputKey:          *outputKey,
+		template:           tmpl,
+
+		fetch: fetchOptions{
+			header:      http.Header(header),
+			saveRawPath: *saveRawPath,
+			ipVersion:   *ipVersion,
+
+			strictHTTPS: *strictHTTPS,
+			unixSocket:  *unixSocket,
+
+			resumeRetries:    *resumeRetries,
+			maxResponseBytes: *maxResponseBytes,
+			verifyChecksum:   *verifyChecksum,
+
+			disableHTTP2:    !*http2,
+			idleConnTimeout: *idleTimeout,
+			tcpKeepAlive:    *keepAlive,
+		},
+		parse: parseOptions{
+			inputFormat: *inputFormat,
+			csvColumn:   *csvColumn,
+
+			maxLineSize: *maxLineSize,
+
+			minTLDLength: *minTLDLength,
+			maxTLDLength: *maxTLDLength,
+
+			onlyCC:      *onlyCC,
+			onlyGeneric: *onlyGeneric,
+
+			idnaVerify: *idnaVerify,
+			idnaStrict: *idnaStrict,
+			noIDNA:     *noIDNA,
+
+			preserveCase: *preserveCase,
+			multiPerLine: *multiPerLine,
+
+			commentPrefixes: commentPrefixes,
+		},
+
+		changelogFile:   *changelogFile,
+		timezone:        loc,
+		statusFile:      *statusFile,
+		metricsTextfile: *metricsTextfile,
+		statsdAddr:      statsdAddr,
+		natsAddr:        natsAddr,
+		natsSubject:     natsSubject,
+
+		dbOpenRetries:    *dbOpenRetries,
+		dbOpenRetryDelay: *dbOpenRetryDelay,
+
+		atomicSwap:      *atomicSwap,
+		stream:          *stream,
+		headCheck:       *headCheck,
+		progress:        *showProgress,
+		reconcileDelete: *reconcileDelete,
+		seed:            *seed,
+		conflict:        *conflict,
+		failFast:        *failFast,
+
+		debug:           *debug,
+		pidFile:         *pidFile,
+		expandEnv:       *expandEnv,
+		acceptFormats:   accepted,
+		mkdir:           *mkdir,
+		mkdirPerm:       os.FileMode(mkdirPerm),
+		noLock:          *noLock,
+		lockTimeout:     *lockTimeout,
+		explainTLD:      *explainTLD,
+		benchSize:       *benchSize,
+		generatePackage: *generatePackage,
+	}
+
+	cfg.parse.kindOverrides = cfg.parse.normalizeKindOverrides(kindOverrides)
+
+	if cfg.pidFile != "" {
+		if err := writePIDFile(cfg.pidFile); err != nil {
+			l.ErrorContext(ctx, err.Error())
+			return exitCodeError
+		}
+		defer func() {
+			if err := removePIDFile(cfg.pidFile); err != nil {
+				l.ErrorContext(ctx, err.Error())
+			}
+		}()
+	}
+
+	cmd := flag.Arg(0)
+
+	// Only syncing and reconciling write to the database, explaining doesn't, hence only they need the lock
+	if (cmd == "" && cfg.explainTLD == "") || cmd == cmdReconcile {
+		if err := ensureDBDir(cfg.sqliteFile, cfg.mkdir, cfg.mkdirPerm); err != nil {
+			l.ErrorContext(ctx, err.Error())
+			return exitCodeError
+		}
+
+		if !cfg.noLock {
+			unlock, err := acquireLock(ctx, cfg.sqliteFile+lockFileSuffix, cfg.lockTimeout)
+			if err != nil {
+				l.ErrorContext(ctx, err.Error())
+				if errors.Is(err, errLocked) {
+					return exitCodeLocked
+				}
+				return exitCodeError
+			}
+			defer func() {
+				if err := unlock(); err != nil {
+					l.ErrorContext(ctx, err.Error())
+				}
+			}()
+		}
+	}
+
+	switch cmd {
+	case "":
+		if cfg.explainTLD != "" {
+			err = explain(ctx, l, os.Stdout, cfg, cfg.explainTLD)
+			break
+		}
+
+		err = run(ctx, l, os.Stdout, cfg)
+	case cmdCheckAll:
+		err = checkAll(ctx, l, os.Stdin, os.Stdout, cfg)
+	case cmdPreflight:
+		err = preflight(ctx, l, os.Stdout, cfg)
+	case cmdReconcile:
+		err = reconcile(ctx, l, os.Stdout, cfg)
+	case cmdPrintConfig:
+		err = printConfig(os.Stdout, cfg)
+	case cmdPrintSchemaSQL:
+		err = printSchemaSQL(os.Stdout)
+	case cmdSchemaCheck:
+		err = schemaCheck(ctx, l, os.Stdout, cfg)
+	case cmdBench:
+		err = bench(ctx, l, os.Stdout, cfg, cfg.benchSize)
+	case cmdLint:
+		err = lint(ctx, l, os.Stdout, cfg, flag.Args()[1:])
+	case cmdGenerate:
+		err = generate(ctx, l, os.Stdout, cfg, cfg.generatePackage, flag.Args()[1:])
+	case cmdCompare:
+		err = compare(ctx, l, os.Stdout, cfg, flag.Args()[1:])
+	default:
+		err = fmt.Errorf("%w: %q", errUnknownCommand, cmd)
+	}
+	if errors.Is(err, errTLDsRemoved) {
+		l.InfoContext(ctx, err.Error())
+		return exitCodeRemoved
+	}
+	if errors.Is(err, errSourcesDiffer) {
+		l.InfoContext(ctx, err.Error())
+		return exitCodeDiffer
+	}
+	if err != nil {
 		l.ErrorContext(ctx, err.Error())
+		if errors.Is(err, errSourceOpen) {
+			return exitCodeSource
+		}
+		return exitCodeError
 	}
+
+	return exitCodeOK
 }
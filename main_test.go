@@ -0,0 +1,351 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const fixturePath = "testdata/tlds-alpha-by-domain.txt"
+
+func newTestLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func newFixtureServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, fixturePath)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func newTestConfig(source, sqliteFile string) config {
+	return config{
+		source:     source,
+		sqliteFile: sqliteFile,
+		format:     formatJSON,
+
+		parse: parseOptions{
+			maxLineSize: bufio.MaxScanTokenSize,
+		},
+	}
+}
+
+func runToString(t *testing.T, cfg config) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := run(t.Context(), newTestLogger(), &buf, cfg); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	return buf.String()
+}
+
+func runToJSON(t *testing.T, sourceURL, sqliteFile string) []tld {
+	t.Helper()
+
+	return runToJSONConfig(t, newTestConfig(sourceURL, sqliteFile))
+}
+
+func runToJSONConfig(t *testing.T, cfg config) []tld {
+	t.Helper()
+
+	out := runToString(t, cfg)
+
+	var tlds []tld
+	if err := json.Unmarshal([]byte(out), &tlds); err != nil {
+		t.Fatalf("failed to decode output %q: %v", out, err)
+	}
+
+	return tlds
+}
+
+func TestRunFixture(t *testing.T) {
+	t.Parallel()
+
+	srv := newFixtureServer(t)
+	sqliteFile := filepath.Join(t.TempDir(), "db.sqlite")
+
+	// First run initializes the database and reports every TLD as new
+	added := runToJSON(t, srv.URL, sqliteFile)
+	if got, want := len(added), 42; got != want {
+		t.Fatalf("got %d added TLDs, want %d", got, want)
+	}
+	for _, want := range []tld{"aaa", "com", "photography", "zw", "рф", "中国", "бг", "امارات"} {
+		if !slices.Contains(added, want) {
+			t.Errorf("added TLDs %v don't contain %q", added, want)
+		}
+	}
+
+	// Second run against the same database must not report anything
+	added = runToJSON(t, srv.URL, sqliteFile)
+	if got, want := len(added), 0; got != want {
+		t.Fatalf("got %d added TLDs on second run, want %d: %v", got, want, added)
+	}
+}
+
+func TestRunNoStdoutOnNoChange(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cfg := newTestConfig(fixturePath, filepath.Join(dir, "db.sqlite"))
+	cfg.noStdoutOnNoChange = true
+
+	if out := runToString(t, cfg); out == "" {
+		t.Fatal("got no output for the initial import")
+	}
+	if out := runToString(t, cfg); out != "" {
+		t.Fatalf("got output %q without any changes", out)
+	}
+
+	// Removals are changes as well, but only on the run they happen
+	cfg.source = filepath.Join(dir, "tlds.txt")
+	if err := os.WriteFile(cfg.source, []byte("# Version 1\nCOM\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if out := runToString(t, cfg); out == "" {
+		t.Fatal("got no output for removed TLDs")
+	}
+	if out := runToString(t, cfg); out != "" {
+		t.Fatalf("got output %q for an unchanged source", out)
+	}
+}
+
+func TestRunSeed(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestConfig(fixturePath, filepath.Join(t.TempDir(), "db.sqlite"))
+	cfg.seed = true
+
+	if got, want := runToString(t, cfg), "[]\n"; got != want {
+		t.Fatalf("got output %q when seeding, want %q", got, want)
+	}
+
+	// Subsequent runs must know about the seeded TLDs
+	cfg.seed = false
+	if got, want := runToString(t, cfg), "[]\n"; got != want {
+		t.Fatalf("got output %q after seeding, want %q", got, want)
+	}
+}
+
+func TestRunPrintRemovedOnly(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cfg := newTestConfig(fixturePath, filepath.Join(dir, "db.sqlite"))
+	cfg.printRemovedOnly = true
+
+	// The initial import can't have removed anything
+	if got, want := runToString(t, cfg), "[]\n"; got != want {
+		t.Fatalf("got output %q for the initial import, want %q", got, want)
+	}
+
+	cfg.source = filepath.Join(dir, "tlds.txt")
+	if err := os.WriteFile(cfg.source, []byte("# Version 1\nCOM\nUK\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := run(t.Context(), newTestLogger(), &buf, cfg); !errors.Is(err, errTLDsRemoved) {
+		t.Fatalf("got error %v, want %v", err, errTLDsRemoved)
+	}
+
+	var removed []tld
+	if err := json.Unmarshal(buf.Bytes(), &removed); err != nil {
+		t.Fatalf("failed to decode output %q: %v", buf.String(), err)
+	}
+	if got, want := len(removed), 40; got != want {
+		t.Fatalf("got %d removed TLDs, want %d: %v", got, want, removed)
+	}
+	if slices.Contains(removed, "com") || !slices.Contains(removed, "aaa") {
+		t.Errorf("unexpected removed TLDs %v", removed)
+	}
+
+	// Removals are only reported once
+	if got, want := runToString(t, cfg), "[]\n"; got != want {
+		t.Fatalf("got output %q for the second run, want %q", got, want)
+	}
+
+	// TLDs listed again are reported as added
+	cfg.printRemovedOnly = false
+	cfg.source = fixturePath
+	if added := runToJSONConfig(t, cfg); !slices.Contains(added, "aaa") || len(added) != 40 {
+		t.Fatalf("got added TLDs %v, want the 40 removed ones", added)
+	}
+}
+
+func TestRunPrintRemovedOnlyChanged(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cfg := newTestConfig(filepath.Join(dir, "tlds.txt"), filepath.Join(dir, "db.sqlite"))
+	if err := os.WriteFile(cfg.source, []byte("COM\nXN--P1AI\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg.parse.noIDNA = true
+	runToString(t, cfg)
+
+	// Decoding now, the stored ASCII form changes to the Unicode one
+	cfg.parse.noIDNA = false
+	cfg.printRemovedOnly = true
+	for _, format := range []string{formatHuman, formatPatch} {
+		cfg.format = format
+
+		var buf bytes.Buffer
+		if err := run(t.Context(), newTestLogger(), &buf, cfg); err != nil && !errors.Is(err, errTLDsRemoved) {
+			t.Fatalf("%s: run failed: %v", format, err)
+		}
+		if strings.Contains(buf.String(), "~") || strings.Contains(buf.String(), "+") {
+			t.Errorf("%s: got output %q, want removals only", format, buf.String())
+		}
+	}
+}
+
+func TestRunRetired(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cfg := newTestConfig(fixturePath, filepath.Join(dir, "db.sqlite"))
+	runToString(t, cfg)
+
+	fixture, err := os.ReadFile(fixturePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withoutNet := filepath.Join(dir, "without-net.txt")
+	if err := os.WriteFile(withoutNet, bytes.Replace(fixture, []byte("NET\n"), nil, 1), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	retire := func(t *testing.T) {
+		t.Helper()
+
+		cfg := cfg
+		cfg.source = withoutNet
+		runToString(t, cfg)
+	}
+	retire(t)
+
+	// Readers treat TLDs marked as removed as gone
+	var buf bytes.Buffer
+	if err := checkAll(t.Context(), newTestLogger(), strings.NewReader("example.net\n"), &buf, cfg); err != nil {
+		t.Fatalf("failed to check: %v", err)
+	}
+	if got, want := buf.String(), `{"domain":"example.net","tld":"net","valid":false}`+"\n"; got != want {
+		t.Errorf("got check result %q, want %q", got, want)
+	}
+	buf.Reset()
+	if err := generate(t.Context(), newTestLogger(), &buf, cfg, "snapshot", nil); err != nil {
+		t.Fatalf("failed to generate: %v", err)
+	}
+	if strings.Contains(buf.String(), `"net"`) {
+		t.Errorf("generated file lists the removed TLD:\n%s", buf.String())
+	}
+	step, err := explainStored(t.Context(), newTestLogger(), cfg, "net")
+	if err != nil || step.OK {
+		t.Errorf("got stored step %+v and error %v, want not stored", step, err)
+	}
+
+	// Listed again, it's restored by every mode exactly once
+	for _, restore := range []struct {
+		name string
+		run  func(t *testing.T) []tld
+	}{
+		{"stream", func(t *testing.T) []tld {
+			cfg := cfg
+			cfg.stream = true
+			return runToJSONConfig(t, cfg)
+		}},
+		{"reconcile", func(t *testing.T) []tld {
+			var buf bytes.Buffer
+			if err := reconcile(t.Context(), newTestLogger(), &buf, cfg); err != nil {
+				t.Fatalf("failed to reconcile: %v", err)
+			}
+			var added []tld
+			if err := json.Unmarshal(buf.Bytes(), &added); err != nil {
+				t.Fatalf("failed to decode output %q: %v", buf.String(), err)
+			}
+			return added
+		}},
+	} {
+		if added := restore.run(t); !slices.Equal(added, []tld{"net"}) {
+			t.Errorf("%s: got added TLDs %v, want [net]", restore.name, added)
+		}
+		if added := runToJSONConfig(t, cfg); len(added) != 0 {
+			t.Errorf("%s: got added TLDs %v on the next run, want none", restore.name, added)
+		}
+		retire(t)
+	}
+}
+
+func TestRunStableJSON(t *testing.T) {
+	t.Parallel()
+
+	for _, format := range []string{formatJSON, formatJSONObject} {
+		t.Run(format, func(t *testing.T) {
+			t.Parallel()
+
+			outputs := make([]string, 0, 2)
+			for range 2 {
+				cfg := newTestConfig(fixturePath, filepath.Join(t.TempDir(), "db.sqlite"))
+				cfg.format = format
+				cfg.runLabel = "test"
+				cfg.parse.preserveCase = true
+				outputs = append(outputs, runToString(t, cfg))
+			}
+
+			if outputs[0] != outputs[1] {
+				t.Fatalf("got different output for the same input:\n%s\n%s", outputs[0], outputs[1])
+			}
+			if out := outputs[0]; !strings.HasSuffix(out, "}\n") && !strings.HasSuffix(out, "]\n") {
+				t.Fatalf("output doesn't end with a single newline: %q", out)
+			}
+
+			if format != formatJSONObject {
+				return
+			}
+			// Keys are emitted in the order of the result fields
+			keys := []string{`"initial_import"`, `"run_label":"test"`, `"added"`, `"removed"`}
+			prev := -1
+			for _, k := range keys {
+				i := strings.Index(outputs[0], k)
+				if i <= prev {
+					t.Fatalf("key %s is out of order in %s", k, outputs[0])
+				}
+				prev = i
+			}
+		})
+	}
+}
+
+func TestHeaderFlag(t *testing.T) {
+	t.Parallel()
+
+	h := make(headerFlag)
+	if err := h.Set("  X-Key :  some: value "); err != nil {
+		t.Fatalf("failed to set header: %v", err)
+	}
+	if got, want := h.String(), "X-Key: some: value"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for _, s := range []string{"", "X-Key", "X-Key value", ": value", "  : value", "X Key: value", "X-Key: a\nb"} {
+		if err := h.Set(s); !errors.Is(err, errInvalidHeader) {
+			t.Errorf("%q: got error %v, want %v", s, err, errInvalidHeader)
+		}
+	}
+}
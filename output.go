@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+)
+
+const (
+	formatJSON       = "json"
+	formatJSONObject = "json-object"
+	formatHuman      = "human"
+	// formatTemplate executes the -template once over the result
+	formatTemplate = "template"
+	// formatPatch emits "+tld" and "-tld" lines to update a plain TLD list with
+	formatPatch = "patch"
+)
+
+const (
+	colorReset  = "\x1b[0m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+)
+
+// stdoutPath is the conventional output path denoting stdout.
+const stdoutPath = "-"
+
+const outputFilePerm = 0o644
+
+var (
+	errUnknownFormat   = errors.New("unknown output format")
+	errInvalidOutput   = errors.New("invalid output")
+	errDuplicateOutput = errors.New("duplicate output")
+	errMissingTemplate = errors.New("template format requires -template")
+)
+
+// outputTarget is a path to write the result to in a specific format.
+// An empty format means the default one.
+type outputTarget struct {
+	path   string
+	format string
+}
+
+// parseOutputTarget parses "path[:format]".
+// A suffix is only considered a format if it doesn't look like a part of the path.
+func parseOutputTarget(s string) (outputTarget, error) {
+	t := outputTarget{path: s}
+	if i := strings.LastIndexByte(s, ':'); i >= 0 && !strings.ContainsAny(s[i+1:], `/\.`) {
+		t.path, t.format = s[:i], s[i+1:]
+	}
+	if t.path == "" {
+		return outputTarget{}, fmt.Errorf("%w: %q, want \"path[:format]\"", errInvalidOutput, s)
+	}
+
+	return t, nil
+}
+
+func (t outputTarget) String() string {
+	if t.format == "" {
+		return t.path
+	}
+	return t.path + ":" + t.format
+}
+
+func (t outputTarget) isStdout() bool {
+	return t.path == stdoutPath
+}
+
+// validateOutputs makes sure that all explicit formats are known and no path is written to twice.
+func validateOutputs(targets []outputTarget) error {
+	seen := make(map[string]struct{}, len(targets))
+	for _, t := range targets {
+		if t.format != "" {
+			if err := validateFormat(t.format); err != nil {
+				return err
+			}
+		}
+
+		p := t.path
+		if !t.isStdout() {
+			p = filepath.Clean(p)
+		}
+		if _, ok := seen[p]; ok {
+			return fmt.Errorf("%w: %q", errDuplicateOutput, t.path)
+		}
+		seen[p] = struct{}{}
+	}
+
+	return nil
+}
+
+// parseOutputTemplate parses the text of -template, which is executed with the result as its data.
+// It returns nil if text is empty.
+func parseOutputTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil //nolint:nilnil // No template is not an error
+	}
+
+	t, err := template.New("output").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+	return t, nil
+}
+
+// validateTemplate makes sure that a template is given if any of the outputs uses the template format.
+func validateTemplate(format string, targets []outputTarget, tmpl *template.Template) error {
+	if tmpl != nil {
+		return nil
+	}
+	if format == formatTemplate {
+		return errMissingTemplate
+	}
+	for _, t := range targets {
+		if t.format == formatTemplate {
+			return fmt.Errorf("%w: %q", errMissingTemplate, t.path)
+		}
+	}
+	return nil
+}
+
+// writeOutputs writes the result to each of targets, using format for those without an explicit one.
+func writeOutputs(stdout io.Writer, targets []outputTarget, format string, res result) error {
+	for _, t := range targets {
+		f := t.format
+		if f == "" {
+			f = format
+		}
+		if err := writeOutput(stdout, t.path, f, res); err != nil {
+			return fmt.Errorf("failed to write to %q: %w", t.path, err)
+		}
+	}
+
+	return nil
+}
+
+func validateFormat(format string) error {
+	switch format {
+	case formatJSON, formatJSONObject, formatHuman, formatTemplate, formatPatch:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", errUnknownFormat, format)
+	}
+}
+
+// writeOutput writes the result to the file at path, or to stdout if path is "-".
+func writeOutput(stdout io.Writer, path, format string, res result) error {
+	res = res.displayed()
+
+	if path == stdoutPath {
+		return writeResult(stdout, format, res)
+	}
+
+	//nolint:gosec // The output is meant to be readable by others
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, outputFilePerm)
+	if err != nil {
+		return fmt.Errorf("failed to open output file: %w", err)
+	}
+
+	if err := writeResult(f, format, res); err != nil {
+		return errors.Join(err, f.Close())
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close output file: %w", err)
+	}
+
+	return nil
+}
+
+func writeResult(w io.Writer, format string, res result) error {
+	switch format {
+	case formatJSON:
+		tlds := res.Added
+		if res.removedOnly {
+			tlds = res.Removed
+		}
+		if res.outputKey != "" {
+			return writeJSON(w, keyedTLDs(tlds, res.outputKey))
+		}
+		return writeJSON(w, tlds)
+	case formatJSONObject:
+		return writeJSON(w, res)
+	case formatHuman:
+		return writeHuman(w, res, useColor(w))
+	case formatTemplate:
+		if res.template == nil {
+			return errMissingTemplate
+		}
+		if err := res.template.Execute(w, res); err != nil {
+			return fmt.Errorf("failed to execute template: %w", err)
+		}
+		return nil
+	case formatPatch:
+		return writePatch(w, res)
+	default:
+		return fmt.Errorf("%w: %q", errUnknownFormat, format)
+	}
+}
+
+// keyedTLDs wraps each TLD into an object holding it under key.
+func keyedTLDs(tlds []tld, key string) []map[string]tld {
+	objs := make([]map[string]tld, 0, len(tlds))
+	for _, t := range tlds {
+		objs = append(objs, map[string]tld{key: t})
+	}
+	return objs
+}
+
+// writeJSON writes v as a single line of JSON.
+// HTML characters are not escaped as the output is never embedded in HTML, e.g. a run label "a&b" is printed as-is.
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("failed to JSON-encode: %w", err)
+	}
+
+	return nil
+}
+
+func writeHuman(w io.Writer, res result, color bool) error {
+	paint := func(c, s string) string {
+		if !color {
+			return s
+		}
+		return c + s + colorReset
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, t := range res.Added {
+		if _, err := fmt.Fprintf(tw, "%s\t%s\n", paint(colorGreen, "+"), t); err != nil {
+			return fmt.Errorf("failed to write: %w", err)
+		}
+	}
+	for _, t := range res.Removed {
+		if _, err := fmt.Fprintf(tw, "%s\t%s\n", paint(colorRed, "-"), t); err != nil {
+			return fmt.Errorf("failed to write: %w", err)
+		}
+	}
+	for _, c := range res.Changed {
+		if _, err := fmt.Fprintf(tw, "%s\t%s -> %s\n", paint(colorYellow, "~"), c.From, c.To); err != nil {
+			return fmt.Errorf("failed to write: %w", err)
+		}
+	}
+
+	summary := fmt.Sprintf("%d added, %d removed", len(res.Added), len(res.Removed))
+	if len(res.Changed) > 0 {
+		summary += fmt.Sprintf(", %d changed", len(res.Changed))
+	}
+	if res.removedOnly {
+		summary = fmt.Sprintf("%d removed", len(res.Removed))
+	}
+	if res.InitialImport {
+		summary += " (initial import)"
+	}
+	if _, err := fmt.Fprintln(tw, summary); err != nil {
+		return fmt.Errorf("failed to write: %w", err)
+	}
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("failed to flush: %w", err)
+	}
+
+	return nil
+}
+
+// writePatch writes a "+tld" line per added and a "-tld" line per removed TLD, without any summary.
+// A changed TLD is written as the removal of its old form followed by the addition of its new one.
+func writePatch(w io.Writer, res result) error {
+	var b strings.Builder
+	for _, t := range res.Removed {
+		b.WriteString("-" + string(t) + "\n")
+	}
+	for _, t := range res.Added {
+		b.WriteString("+" + string(t) + "\n")
+	}
+	for _, c := range res.Changed {
+		b.WriteString("-" + string(c.From) + "\n+" + string(c.To) + "\n")
+	}
+
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return fmt.Errorf("failed to write: %w", err)
+	}
+	return nil
+}
+
+// useColor reports whether colored output should be written to w.
+// Color is only used for terminals and can be disabled by setting NO_COLOR, see https://no-color.org.
+func useColor(w io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+
+	return isTerminal(w)
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// displayed returns a copy of r with all TLDs replaced by their display form.
+func (r result) displayed() result {
+	if len(r.display) == 0 {
+		return r
+	}
+
+	r.Added = r.displayedTLDs(r.Added)
+	r.Removed = r.displayedTLDs(r.Removed)
+
+	return r
+}
+
+func (r result) displayedTLDs(tlds []tld) []tld {
+	res := make([]tld, 0, len(tlds))
+	for _, t := range tlds {
+		if d, ok := r.display[t]; ok {
+			t = tld(d)
+		}
+		res = append(res, t)
+	}
+	return res
+}
@@ -0,0 +1,192 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteResultJSON(t *testing.T) {
+	t.Parallel()
+
+	res := result{
+		Added:   []tld{"com", "xn--p1ai"},
+		Removed: []tld{"zw"},
+		display: map[tld]string{"xn--p1ai": "рф"},
+	}
+
+	for _, tc := range []struct {
+		name string
+		res  func(result) result
+		want string
+	}{
+		{
+			name: "bare",
+			res:  func(r result) result { return r },
+			want: `["com","рф"]` + "\n",
+		},
+		{
+			name: "output-key",
+			res:  func(r result) result { r.outputKey = "domain"; return r },
+			want: `[{"domain":"com"},{"domain":"рф"}]` + "\n",
+		},
+		{
+			name: "removed-only",
+			res:  func(r result) result { r.outputKey = "suffix"; r.removedOnly = true; return r },
+			want: `[{"suffix":"zw"}]` + "\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			if err := writeOutput(&buf, stdoutPath, formatJSON, tc.res(res)); err != nil {
+				t.Fatalf("writeOutput failed: %v", err)
+			}
+			if got := buf.String(); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriteJSONNoHTMLEscape(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, result{RunLabel: "<prod&eu>", Added: []tld{"рф"}, Removed: []tld{}}); err != nil {
+		t.Fatalf("writeJSON failed: %v", err)
+	}
+	const want = `{"initial_import":false,"run_label":"<prod&eu>","added":["рф"],"removed":[]}` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWriteResultTemplate(t *testing.T) {
+	t.Parallel()
+
+	tmpl, err := parseOutputTemplate("{{.RunLabel}}:{{range .Added}} +{{.}}{{end}}{{range .Removed}} -{{.}}{{end}}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := result{
+		RunLabel: "prod",
+		Added:    []tld{"com", "xn--p1ai"},
+		Removed:  []tld{"zw"},
+		display:  map[tld]string{"xn--p1ai": "рф"},
+		template: tmpl,
+	}
+
+	var buf bytes.Buffer
+	if err := writeOutput(&buf, stdoutPath, formatTemplate, res); err != nil {
+		t.Fatalf("writeOutput failed: %v", err)
+	}
+	if got, want := buf.String(), "prod: +com +рф -zw\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	res.template = nil
+	if err := writeOutput(&buf, stdoutPath, formatTemplate, res); !errors.Is(err, errMissingTemplate) {
+		t.Errorf("got error %v without a template, want %v", err, errMissingTemplate)
+	}
+	if err := validateTemplate(formatJSON, []outputTarget{{path: "out.txt", format: formatTemplate}}, nil); !errors.Is(err, errMissingTemplate) {
+		t.Errorf("got error %v validating a template output without a template, want %v", err, errMissingTemplate)
+	}
+}
+
+func TestWriteResultPatch(t *testing.T) {
+	t.Parallel()
+
+	res := result{
+		Added:   []tld{"com", "xn--p1ai"},
+		Removed: []tld{"zw"},
+		Changed: []tldChange{{ASCII: "xn--ko-eka", From: "öko", To: "oeko"}},
+		display: map[tld]string{"xn--p1ai": "рф"},
+	}
+
+	var buf bytes.Buffer
+	if err := writeOutput(&buf, stdoutPath, formatPatch, res); err != nil {
+		t.Fatalf("writeOutput failed: %v", err)
+	}
+	if got, want := buf.String(), "-zw\n+com\n+рф\n-öko\n+oeko\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseOutputTarget(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		in   string
+		want outputTarget
+	}{
+		{"-", outputTarget{path: "-"}},
+		{"result.json", outputTarget{path: "result.json"}},
+		{"result.json:json", outputTarget{path: "result.json", format: "json"}},
+		{"-:human", outputTarget{path: "-", format: "human"}},
+		{"dir:1/out.txt", outputTarget{path: "dir:1/out.txt"}},
+		{"out:v1.txt", outputTarget{path: "out:v1.txt"}},
+	} {
+		got, err := parseOutputTarget(tc.in)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%q: got %+v, want %+v", tc.in, got, tc.want)
+		}
+	}
+
+	if _, err := parseOutputTarget(":json"); !errors.Is(err, errInvalidOutput) {
+		t.Errorf("got error %v, want %v", err, errInvalidOutput)
+	}
+}
+
+func TestValidateOutputs(t *testing.T) {
+	t.Parallel()
+
+	if err := validateOutputs([]outputTarget{{path: "a.json", format: "json"}, {path: "-", format: "human"}}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := validateOutputs([]outputTarget{{path: "a.json", format: "text"}}); !errors.Is(err, errUnknownFormat) {
+		t.Errorf("got error %v, want %v", err, errUnknownFormat)
+	}
+	if err := validateOutputs([]outputTarget{{path: "a.json"}, {path: "./a.json", format: "human"}}); !errors.Is(err, errDuplicateOutput) {
+		t.Errorf("got error %v, want %v", err, errDuplicateOutput)
+	}
+}
+
+func TestWriteOutputs(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	targets := []outputTarget{
+		{path: filepath.Join(dir, "result.json")},
+		{path: filepath.Join(dir, "summary.txt"), format: formatHuman},
+	}
+	res := result{Added: []tld{"com"}}
+
+	if err := writeOutputs(nil, targets, formatJSON, res); err != nil {
+		t.Fatalf("writeOutputs failed: %v", err)
+	}
+
+	b, err := os.ReadFile(targets[0].path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), `["com"]`+"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	b, err = os.ReadFile(targets[1].path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(b); !strings.Contains(got, "1 added") {
+		t.Errorf("got %q, want a human summary", got)
+	}
+}
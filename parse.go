@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+const scanBufferInitialSize = 4 * 1024
+
+// Reasons for skipping a line of the source, as logged at debug level.
+const (
+	skipReasonEmpty     = "empty"
+	skipReasonComment   = "comment"
+	skipReasonIDNA      = "idna_round_trip"
+	skipReasonInvalid   = "invalid_label"
+	skipReasonLength    = "length"
+	skipReasonKind      = "kind"
+	skipReasonDuplicate = "duplicate"
+)
+
+// maxWarningSamples caps the number of warnings sampled by parseWarnings.
+const maxWarningSamples = 20
+
+// Kinds of TLDs, see parseOptions.kindOf.
+const (
+	kindCC      = "cc"
+	kindGeneric = "generic"
+)
+
+// Kinds of parse warnings in addition to the skip reasons.
+const (
+	warningDecode    = "decode_failed"
+	warningCollision = "collision"
+)
+
+type parseOptions struct {
+	// inputFormat is one of the inputFormat* constants,
+	// csvColumn selects the column holding the TLD for CSV input.
+	inputFormat string
+	csvColumn   string
+
+	maxLineSize int
+
+	// minTLDLength and maxTLDLength restrict the length of accepted TLDs in runes.
+	// Zero means no restriction.
+	minTLDLength int
+	maxTLDLength int
+
+	// onlyCC keeps country-code TLDs only, onlyGeneric drops them
+	onlyCC      bool
+	onlyGeneric bool
+
+	// kindOverrides maps TLDs in their stored form to their kind, taking precedence over isCountryCode
+	kindOverrides map[string]string
+
+	// idnaVerify checks that decoded TLDs encode back to their original ASCII form,
+	// idnaStrict additionally drops those which don't.
+	idnaVerify bool
+	idnaStrict bool
+
+	// noIDNA stores TLDs in their ASCII form as listed in the source instead of decoding punycode
+	noIDNA bool
+
+	// multiPerLine splits lines on whitespace and commas, each token being a TLD of its own
+	multiPerLine bool
+
+	// preserveCase makes parseTLDs additionally report the original casing of ASCII TLDs.
+	preserveCase bool
+
+	// commentPrefixes are the prefixes of lines to skip, defaultCommentPrefix if empty
+	commentPrefixes []string
+
+	// warnings, if set, collects the issues found while parsing
+	warnings *parseWarnings
+
+	// version, if set, receives the version of the list stated by its header, see listVersion
+	version *string
+
+	// trace, if set, is called with the outcome of each stage a line of the source goes through, see explain
+	trace func(parseStep)
+
+	// streaming skips tracking duplicates and collisions unless debugging, keeping memory usage independent of the source
+	streaming bool
+}
+
+// sourceLine is a line of the source along with its 1-based number.
+type sourceLine struct {
+	n    int
+	text string
+}
+
+// traceStepFound is the first stage of each token traced, see parseStep.
+const traceStepFound = "found"
+
+// parseStep is the outcome of a single stage a token of the source went through in scanTLDs.
+// Each token starts with a traceStepFound step, so does a comment line as it's not split into tokens.
+type parseStep struct {
+	Line int
+	// Text is the token as listed in the source, or the whole line for comments
+	Text string
+	// TLD is Text in the form TLDs are stored in as far as it's known at this stage
+	TLD    string
+	Step   string
+	OK     bool
+	Detail string
+}
+
+// parseWarning is an issue found in a single line of the source.
+type parseWarning struct {
+	Kind   string `json:"kind"`
+	Line   int    `json:"line"`
+	Text   string `json:"text"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// parseWarnings counts the issues found while parsing by kind and samples the first maxWarningSamples of them.
+type parseWarnings struct {
+	Counts  map[string]int `json:"counts"`
+	Samples []parseWarning `json:"samples"`
+}
+
+// add records a warning of kind about line n of the source. It's a no-op on a nil receiver.
+func (w *parseWarnings) add(kind string, n int, text, detail string) {
+	if w == nil {
+		return
+	}
+
+	if w.Counts == nil {
+		w.Counts = make(map[string]int)
+	}
+	w.Counts[kind]++
+	if len(w.Samples) < maxWarningSamples {
+		w.Samples = append(w.Samples, parseWarning{Kind: kind, Line: n, Text: text, Detail: detail})
+	}
+}
+
+func (w *parseWarnings) empty() bool {
+	return w == nil || len(w.Counts) == 0
+}
+
+// defaultCommentPrefix starts comments in the IANA list.
+const defaultCommentPrefix = "#"
+
+// listVersion returns the version stated by a header line of the IANA list such as
+// "# Version 2025061000, Last Updated Tue Jun 10 07:07:01 2025 UTC".
+func listVersion(line string) (string, bool) {
+	rest, ok := strings.CutPrefix(line, "# Version ")
+	if !ok {
+		return "", false
+	}
+	v, _, _ := strings.Cut(rest, ",")
+	v = strings.TrimSpace(v)
+	return v, v != ""
+}
+
+// isComment reports whether line starts with any of the comment prefixes.
+func (o parseOptions) isComment(line string) bool {
+	if len(o.commentPrefixes) == 0 {
+		return strings.HasPrefix(line, defaultCommentPrefix)
+	}
+	for _, p := range o.commentPrefixes {
+		if strings.HasPrefix(line, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitLine returns the TLDs listed in line, which is split on whitespace and commas if o.multiPerLine is set.
+// It returns nothing for an empty line.
+func (o parseOptions) splitLine(line string) []string {
+	if !o.multiPerLine {
+		if line == "" {
+			return nil
+		}
+		return []string{line}
+	}
+	return strings.FieldsFunc(line, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// validLabel reports whether t is a single DNS label, i.e. letters, digits and inner hyphens only.
+// It rules out junk like error pages parsed as a source.
+func validLabel(t string) bool {
+	if t == "" || strings.HasPrefix(t, "-") || strings.HasSuffix(t, "-") {
+		return false
+	}
+	for _, r := range t {
+		if r != '-' && !unicode.IsLetter(r) && !unicode.IsMark(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// filtered reports whether t is left out by the length or kind filters.
+func (o parseOptions) filtered(t string) bool {
+	return !o.lengthInRange(t) || !o.kindAccepted(t)
+}
+
+func (o parseOptions) lengthInRange(t string) bool {
+	n := utf8.RuneCountInString(t)
+	if o.minTLDLength > 0 && n < o.minTLDLength {
+		return false
+	}
+	if o.maxTLDLength > 0 && n > o.maxTLDLength {
+		return false
+	}
+	return true
+}
+
+func (o parseOptions) kindAccepted(t string) bool {
+	kind, _ := o.kindOf(t)
+	switch {
+	case o.onlyCC:
+		return kind == kindCC
+	case o.onlyGeneric:
+		return kind == kindGeneric
+	default:
+		return true
+	}
+}
+
+// kindOf classifies t as kindCC or kindGeneric and reports whether the kind was taken from o.kindOverrides.
+func (o parseOptions) kindOf(t string) (string, bool) {
+	if kind, ok := o.kindOverrides[t]; ok {
+		return kind, true
+	}
+	if isCountryCode(t) {
+		return kindCC, false
+	}
+	return kindGeneric, false
+}
+
+// normalizeKindOverrides returns overrides keyed by the form TLDs are stored in, accepting keys in either form.
+func (o parseOptions) normalizeKindOverrides(overrides map[string]string) map[string]string {
+	if len(overrides) == 0 {
+		return nil
+	}
+
+	toStored := o.storedForm(idna.New(idna.BidiRule()))
+	m := make(map[string]string, len(overrides))
+	for t, kind := range overrides {
+		if s, err := toStored(t); err == nil {
+			t = s
+		}
+		m[t] = kind
+	}
+	return m
+}
+
+// storedForm returns the conversion of a label to the form TLDs are stored in,
+// which is the Unicode form unless o.noIDNA is set.
+func (o parseOptions) storedForm(prof *idna.Profile) func(string) (string, error) {
+	if o.noIDNA {
+		return prof.ToASCII
+	}
+	return prof.ToUnicode
+}
+
+// isCountryCode reports whether t is a two-letter ASCII country-code TLD.
+func isCountryCode(t string) bool {
+	return len(t) == 2 &&
+		'a' <= t[0] && t[0] <= 'z' &&
+		'a' <= t[1] && t[1] <= 'z'
+}
+
+// parseTLDs parses the TLDs listed in r.
+// If opts.preserveCase is set, it also returns the original casing of each ASCII TLD which differs from its stored, lowercase form.
+func parseTLDs(
+	ctx context.Context,
+	l *slog.Logger,
+	r io.Reader,
+	opts parseOptions,
+) ([]tld, map[tld]string, error) {
+	c := newTLDCollector(opts.preserveCase)
+	if err := scanTLDs(ctx, l, r, opts, c.add); err != nil {
+		return nil, nil, err
+	}
+
+	return c.tlds, c.display, nil
+}
+
+// tldCollector collects the TLDs passed to its add method, see scanTLDs.
+type tldCollector struct {
+	tlds    []tld
+	display map[tld]string
+}
+
+func newTLDCollector(preserveCase bool) *tldCollector {
+	var c tldCollector
+	if preserveCase {
+		c.display = make(map[tld]string)
+	}
+	return &c
+}
+
+func (c *tldCollector) add(t tld, orig string) error {
+	c.tlds = append(c.tlds, t)
+	if orig != "" {
+		c.display[t] = orig
+	}
+	return nil
+}
+
+// scanTLDs parses the TLDs listed in r and passes each of them to emit as soon as it's parsed.
+// orig is the original casing of the TLD if opts.preserveCase is set and it differs from the TLD, empty otherwise.
+// Scanning stops at the first error returned by emit.
+func scanTLDs(
+	ctx context.Context,
+	l *slog.Logger,
+	r io.Reader,
+	opts parseOptions,
+	emit func(t tld, orig string) error,
+) error {
+	prof := idna.New(idna.BidiRule())
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, min(opts.maxLineSize, scanBufferInitialSize)), opts.maxLineSize)
+
+	// Tracking duplicates requires memory proportional to the source, which streaming avoids unless debugging
+	debug := l.Enabled(ctx, slog.LevelDebug)
+	var seen map[string]sourceLine
+	if debug || !opts.streaming {
+		seen = make(map[string]sourceLine)
+	}
+	skip := func(n int, text, reason string) {
+		if debug {
+			l.DebugContext(
+				ctx,
+				"skipped line",
+				"line", n,
+				"text", text,
+				"reason", reason,
+			)
+		}
+	}
+	trace := func(n int, text, t, step string, ok bool, detail string) bool {
+		if opts.trace != nil {
+			opts.trace(parseStep{Line: n, Text: text, TLD: t, Step: step, OK: ok, Detail: detail})
+		}
+		return ok
+	}
+
+	var (
+		n             int
+		skippedLength int
+		skippedKind   int
+	)
+	for scanner.Scan() {
+		n++
+
+		text := strings.TrimSpace(scanner.Text())
+		if opts.isComment(text) {
+			if v, ok := listVersion(text); ok && opts.version != nil && *opts.version == "" {
+				*opts.version = v
+			}
+			lower := strings.ToLower(text)
+			trace(n, text, lower, traceStepFound, true, fmt.Sprintf("%q", text))
+			trace(n, text, lower, "not_comment", false, "")
+			skip(n, text, skipReasonComment)
+			continue
+		}
+		tokens := opts.splitLine(text)
+		if len(tokens) == 0 {
+			skip(n, text, skipReasonEmpty)
+			continue
+		}
+
+		for _, orig := range tokens {
+			line := strings.ToLower(orig)
+
+			trace(n, orig, line, traceStepFound, true, fmt.Sprintf("%q", orig))
+			trace(n, orig, line, "lowercased", true, line)
+			trace(n, orig, line, "not_comment", true, "")
+
+			t := line
+			if !opts.noIDNA {
+				u, err := prof.ToUnicode(line)
+				if err != nil {
+					l.ErrorContext(ctx, fmt.Errorf("failed to puny decode %q: %w", line, err).Error())
+					opts.warnings.add(warningDecode, n, orig, err.Error())
+					trace(n, orig, u, "punycode_decoded", false, err.Error())
+				} else {
+					trace(n, orig, u, "punycode_decoded", true, u)
+				}
+				t = u
+			}
+
+			if opts.idnaVerify || opts.idnaStrict {
+				ascii, err := prof.ToASCII(t)
+				detail := ascii
+				if err != nil {
+					detail = err.Error()
+				}
+				if !trace(n, orig, t, "idna_round_trip", err == nil && ascii == line, detail) {
+					l.WarnContext(
+						ctx,
+						"TLD doesn't round-trip through IDNA",
+						"line", line,
+						"decoded", t,
+						"encoded", ascii,
+						"err", err,
+					)
+					if err == nil {
+						detail = fmt.Sprintf("encodes to %q", ascii)
+					}
+					opts.warnings.add(skipReasonIDNA, n, orig, detail)
+					if opts.idnaStrict {
+						skip(n, orig, skipReasonIDNA)
+						continue
+					}
+				}
+			}
+
+			if !trace(n, orig, t, "valid_label", validLabel(t), "") {
+				l.WarnContext(
+					ctx,
+					"skipped invalid TLD",
+					"line", n,
+					"text", orig,
+				)
+				skip(n, orig, skipReasonInvalid)
+				opts.warnings.add(skipReasonInvalid, n, orig, "")
+				continue
+			}
+			if !trace(n, orig, t, "length", opts.lengthInRange(t), "") {
+				skippedLength++
+				skip(n, orig, skipReasonLength)
+				opts.warnings.add(skipReasonLength, n, orig, "")
+				continue
+			}
+			kind, overridden := opts.kindOf(t)
+			if overridden {
+				kind += " (override)"
+			}
+			if !trace(n, orig, t, "kind", opts.kindAccepted(t), kind) {
+				skippedKind++
+				skip(n, orig, skipReasonKind)
+				opts.warnings.add(skipReasonKind, n, orig, "")
+				continue
+			}
+
+			if seen != nil {
+				first, ok := seen[t]
+				switch {
+				case !ok:
+					seen[t] = sourceLine{n: n, text: orig}
+				case first.text != orig:
+					l.WarnContext(
+						ctx,
+						"TLD is listed in different forms",
+						"tld", t,
+						"line", n,
+						"text", orig,
+						"first_line", first.n,
+						"first_text", first.text,
+					)
+					// Emitted once only, like any duplicate, just with a warning of its own
+					detail := fmt.Sprintf("first listed as %q on line %d", first.text, first.n)
+					trace(n, orig, t, "not_duplicate", false, detail)
+					skip(n, orig, skipReasonDuplicate)
+					opts.warnings.add(warningCollision, n, orig, detail)
+					continue
+				default:
+					// Untracked duplicates are emitted when streaming, storing them is a no-op
+					detail := fmt.Sprintf("first listed on line %d", first.n)
+					trace(n, orig, t, "not_duplicate", false, detail)
+					skip(n, orig, skipReasonDuplicate)
+					opts.warnings.add(skipReasonDuplicate, n, orig, detail)
+					continue
+				}
+			}
+
+			trace(n, orig, t, "emitted", true, "")
+
+			// Punycode-decoded TLDs have no casing of their own
+			if !opts.preserveCase || t != line || orig == line {
+				orig = ""
+			}
+			if err := emit(tld(t), orig); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to scan source: %w", err)
+	}
+
+	if skippedLength > 0 {
+		l.InfoContext(
+			ctx,
+			"skipped TLDs outside of the configured length range",
+			"count", skippedLength,
+		)
+	}
+	if skippedKind > 0 {
+		l.InfoContext(
+			ctx,
+			"skipped TLDs of the excluded kind",
+			"count", skippedKind,
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,441 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParseTLDsLongLine(t *testing.T) {
+	t.Parallel()
+
+	const maxLineSize = 64
+
+	src := "# Version 2025061000\nCOM\n" + strings.Repeat("A", 2*maxLineSize) + "\nNET\n"
+
+	_, _, err := parseTLDs(t.Context(), newTestLogger(), strings.NewReader(src), parseOptions{
+		maxLineSize: maxLineSize,
+	})
+	if !errors.Is(err, bufio.ErrTooLong) {
+		t.Fatalf("got error %v, want %v", err, bufio.ErrTooLong)
+	}
+
+	tlds, _, err := parseTLDs(t.Context(), newTestLogger(), strings.NewReader(src), parseOptions{
+		maxLineSize: 4 * maxLineSize,
+	})
+	if err != nil {
+		t.Fatalf("failed to parse with a larger buffer: %v", err)
+	}
+	if got, want := len(tlds), 3; got != want {
+		t.Fatalf("got %d TLDs, want %d", got, want)
+	}
+}
+
+func TestParseTLDsReadError(t *testing.T) {
+	t.Parallel()
+
+	errTruncated := errors.New("connection reset")
+
+	// A download which breaks off partway must not be mistaken for a complete, smaller list
+	r := io.MultiReader(
+		strings.NewReader("# Version 2025061000\nCOM\nNET\n"),
+		iotest.ErrReader(errTruncated),
+	)
+
+	tlds, _, err := parseTLDs(t.Context(), newTestLogger(), r, parseOptions{
+		maxLineSize: bufio.MaxScanTokenSize,
+	})
+	if !errors.Is(err, errTruncated) {
+		t.Fatalf("got error %v, want %v", err, errTruncated)
+	}
+	if tlds != nil {
+		t.Fatalf("got TLDs %v, want none", tlds)
+	}
+}
+
+func TestParseTLDsLengthRange(t *testing.T) {
+	t.Parallel()
+
+	const src = "# Version 2025061000\nAC\nCOM\nINFO\nMUSEUM\nXN--P1AI\nXN--90AE\n"
+
+	for _, tc := range []struct {
+		name     string
+		min, max int
+		want     []tld
+	}{
+		{"unrestricted", 0, 0, []tld{"ac", "com", "info", "museum", "рф", "бг"}},
+		{"min", 3, 0, []tld{"com", "info", "museum"}},
+		{"max", 0, 3, []tld{"ac", "com", "рф", "бг"}},
+		{"range", 3, 4, []tld{"com", "info"}},
+		{"exact", 2, 2, []tld{"ac", "рф", "бг"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			tlds, _, err := parseTLDs(t.Context(), newTestLogger(), strings.NewReader(src), parseOptions{
+				maxLineSize: bufio.MaxScanTokenSize,
+
+				minTLDLength: tc.min,
+				maxTLDLength: tc.max,
+			})
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+			if !slices.Equal(tlds, tc.want) {
+				t.Fatalf("got TLDs %v, want %v", tlds, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseTLDsPreserveCase(t *testing.T) {
+	t.Parallel()
+
+	const src = "# Version 2025061000\nCOM\nnet\nOrg\nXN--P1AI\n"
+
+	tlds, display, err := parseTLDs(t.Context(), newTestLogger(), strings.NewReader(src), parseOptions{
+		maxLineSize: bufio.MaxScanTokenSize,
+
+		preserveCase: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if want := []tld{"com", "net", "org", "рф"}; !slices.Equal(tlds, want) {
+		t.Fatalf("got TLDs %v, want %v", tlds, want)
+	}
+	if want := map[tld]string{"com": "COM", "org": "Org"}; !maps.Equal(display, want) {
+		t.Fatalf("got display forms %v, want %v", display, want)
+	}
+}
+
+func TestParseTLDsNoIDNA(t *testing.T) {
+	t.Parallel()
+
+	const src = "# Version 2025061000\nCOM\nXN--P1AI\n"
+
+	tlds, _, err := parseTLDs(t.Context(), newTestLogger(), strings.NewReader(src), parseOptions{
+		maxLineSize: bufio.MaxScanTokenSize,
+
+		noIDNA: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if want := []tld{"com", "xn--p1ai"}; !slices.Equal(tlds, want) {
+		t.Fatalf("got TLDs %v, want %v", tlds, want)
+	}
+}
+
+func TestParseTLDsMultiPerLine(t *testing.T) {
+	t.Parallel()
+
+	const src = "# Version 2025061000, not a TLD\nCOM NET,ORG\n\tINFO ,, XN--P1AI,\n,\nDE\n"
+
+	tlds, _, err := parseTLDs(t.Context(), newTestLogger(), strings.NewReader(src), parseOptions{
+		maxLineSize: bufio.MaxScanTokenSize,
+
+		multiPerLine: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if want := []tld{"com", "net", "org", "info", "рф", "de"}; !slices.Equal(tlds, want) {
+		t.Fatalf("got TLDs %v, want %v", tlds, want)
+	}
+
+	// Without it, the line is taken as a whole, which isn't a valid TLD
+	tlds, _, err = parseTLDs(t.Context(), newTestLogger(), strings.NewReader("COM NET\n"), parseOptions{
+		maxLineSize: bufio.MaxScanTokenSize,
+	})
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if want := []tld{}; !slices.Equal(tlds, want) {
+		t.Fatalf("got TLDs %v without -multi-per-line, want %v", tlds, want)
+	}
+}
+
+func TestParseTLDsKind(t *testing.T) {
+	t.Parallel()
+
+	const src = "# Version 2025061000\nCOM\nPHOTOGRAPHY\nUK\nXN--P1AI\n"
+
+	for _, tc := range []struct {
+		name string
+		opts parseOptions
+		want []tld
+	}{
+		{"all", parseOptions{}, []tld{"com", "photography", "uk", "рф"}},
+		{"only-cc", parseOptions{onlyCC: true}, []tld{"uk"}},
+		{"only-generic", parseOptions{onlyGeneric: true}, []tld{"com", "photography", "рф"}},
+		{"override-cc", parseOptions{onlyCC: true, kindOverrides: map[string]string{"рф": kindCC}}, []tld{"uk", "рф"}},
+		{"override-generic", parseOptions{onlyGeneric: true, kindOverrides: map[string]string{"uk": kindGeneric}}, []tld{"com", "photography", "uk", "рф"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			opts := tc.opts
+			opts.maxLineSize = bufio.MaxScanTokenSize
+
+			tlds, _, err := parseTLDs(t.Context(), newTestLogger(), strings.NewReader(src), opts)
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+			if !slices.Equal(tlds, tc.want) {
+				t.Fatalf("got TLDs %v, want %v", tlds, tc.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeKindOverrides(t *testing.T) {
+	t.Parallel()
+
+	overrides := map[string]string{"xn--p1ai": kindCC, "uk": kindGeneric}
+
+	got := parseOptions{}.normalizeKindOverrides(overrides)
+	if want := map[string]string{"рф": kindCC, "uk": kindGeneric}; !maps.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	got = parseOptions{noIDNA: true}.normalizeKindOverrides(map[string]string{"рф": kindCC})
+	if want := map[string]string{"xn--p1ai": kindCC}; !maps.Equal(got, want) {
+		t.Fatalf("got %v with -no-idna, want %v", got, want)
+	}
+
+	if kind, overridden := (parseOptions{kindOverrides: got}).kindOf("xn--p1ai"); kind != kindCC || !overridden {
+		t.Fatalf("got kind %q (overridden %t), want %q (overridden)", kind, overridden, kindCC)
+	}
+}
+
+func TestParseTLDsIDNARoundTrip(t *testing.T) {
+	t.Parallel()
+
+	// "xn--com-" decodes to "com" which encodes back to "com", not "xn--com-"
+	const src = "# Version 2025061000\nXN--P1AI\nXN--COM-\nNET\n"
+
+	for _, tc := range []struct {
+		name string
+		opts parseOptions
+		want []tld
+	}{
+		{"off", parseOptions{}, []tld{"рф", "com", "net"}},
+		{"verify", parseOptions{idnaVerify: true}, []tld{"рф", "com", "net"}},
+		{"strict", parseOptions{idnaStrict: true}, []tld{"рф", "net"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			opts := tc.opts
+			opts.maxLineSize = bufio.MaxScanTokenSize
+
+			tlds, _, err := parseTLDs(t.Context(), newTestLogger(), strings.NewReader(src), opts)
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+			if !slices.Equal(tlds, tc.want) {
+				t.Fatalf("got TLDs %v, want %v", tlds, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseTLDsSkipReasons(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	l := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	src := "# Version 2025061000\n\nCOM\nCOM\nAC\nPHOTOGRAPHY\n"
+	opts := parseOptions{
+		maxLineSize:  bufio.MaxScanTokenSize,
+		maxTLDLength: 5,
+		onlyGeneric:  true,
+	}
+	tlds, _, err := parseTLDs(t.Context(), l, strings.NewReader(src), opts)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	// Skipped lines, including the duplicate, aren't emitted
+	if want := []tld{"com"}; !slices.Equal(tlds, want) {
+		t.Fatalf("got TLDs %v, want %v", tlds, want)
+	}
+
+	var reasons []string
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var entry struct {
+			Msg    string `json:"msg"`
+			Line   int    `json:"line"`
+			Reason string `json:"reason"`
+		}
+		if err := dec.Decode(&entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.Msg == "skipped line" {
+			reasons = append(reasons, fmt.Sprintf("%d:%s", entry.Line, entry.Reason))
+		}
+	}
+
+	want := []string{"1:comment", "2:empty", "4:duplicate", "5:kind", "6:length"}
+	if !slices.Equal(reasons, want) {
+		t.Fatalf("got skip reasons %v, want %v", reasons, want)
+	}
+}
+
+func TestParseTLDsCommentPrefixes(t *testing.T) {
+	t.Parallel()
+
+	const src = "# Version 2025061000\n// generated\n; custom\nCOM\nNET\n"
+
+	for _, tc := range []struct {
+		name     string
+		prefixes []string
+		// invalid is the number of lines which aren't taken as comments, yet aren't valid TLDs either
+		invalid int
+	}{
+		{"default", nil, 2},
+		{"multiple", []string{"#", "//", ";"}, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			warnings := new(parseWarnings)
+			tlds, _, err := parseTLDs(t.Context(), newTestLogger(), strings.NewReader(src), parseOptions{
+				maxLineSize:     bufio.MaxScanTokenSize,
+				commentPrefixes: tc.prefixes,
+				warnings:        warnings,
+			})
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+			if want := []tld{"com", "net"}; !slices.Equal(tlds, want) {
+				t.Fatalf("got %v, want %v", tlds, want)
+			}
+			if got := warnings.Counts[skipReasonInvalid]; got != tc.invalid {
+				t.Fatalf("got %d invalid lines, want %d", got, tc.invalid)
+			}
+		})
+	}
+}
+
+func TestParseTLDsCollisions(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	l := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	const src = "COM\ncom\nXN--P1AI\nрф\nNET\nNET\n"
+	tlds, _, err := parseTLDs(t.Context(), l, strings.NewReader(src), parseOptions{maxLineSize: bufio.MaxScanTokenSize})
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	// Each TLD is emitted once, colliding forms are warned about in addition
+	if want := []tld{"com", "рф", "net"}; !slices.Equal(tlds, want) {
+		t.Fatalf("got TLDs %v, want %v", tlds, want)
+	}
+
+	var collisions []string
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var entry struct {
+			Msg       string `json:"msg"`
+			Line      int    `json:"line"`
+			FirstLine int    `json:"first_line"`
+		}
+		if err := dec.Decode(&entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.Msg == "TLD is listed in different forms" {
+			collisions = append(collisions, fmt.Sprintf("%d:%d", entry.FirstLine, entry.Line))
+		}
+	}
+
+	if want := []string{"1:2", "3:4"}; !slices.Equal(collisions, want) {
+		t.Fatalf("got collisions %v, want %v", collisions, want)
+	}
+}
+
+func TestParseTLDsWarnings(t *testing.T) {
+	t.Parallel()
+
+	var src strings.Builder
+	src.WriteString("COM\ncom\nNET\nNET\n")
+	for i := range maxWarningSamples {
+		fmt.Fprintf(&src, "LONG%d\n", i)
+	}
+
+	warnings := new(parseWarnings)
+	if _, _, err := parseTLDs(t.Context(), newTestLogger(), strings.NewReader(src.String()), parseOptions{
+		maxLineSize:  bufio.MaxScanTokenSize,
+		maxTLDLength: 3,
+		warnings:     warnings,
+	}); err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	want := map[string]int{
+		warningCollision:    1,
+		skipReasonDuplicate: 1,
+		skipReasonLength:    maxWarningSamples,
+	}
+	if !maps.Equal(warnings.Counts, want) {
+		t.Fatalf("got counts %v, want %v", warnings.Counts, want)
+	}
+	if got := len(warnings.Samples); got != maxWarningSamples {
+		t.Fatalf("got %d samples, want %d", got, maxWarningSamples)
+	}
+	if got, want := warnings.Samples[0], (parseWarning{
+		Kind:   warningCollision,
+		Line:   2,
+		Text:   "com",
+		Detail: `first listed as "COM" on line 1`,
+	}); got != want {
+		t.Fatalf("got first sample %+v, want %+v", got, want)
+	}
+}
+
+func TestValidLabel(t *testing.T) {
+	t.Parallel()
+
+	for _, label := range []string{"com", "xn--p1ai", "рф", "भारत", "a1"} {
+		if !validLabel(label) {
+			t.Errorf("%q: got invalid, want valid", label)
+		}
+	}
+	for _, label := range []string{"", "service unavailable", "-com", "com-", "co.uk", "<html>", "com\t"} {
+		if validLabel(label) {
+			t.Errorf("%q: got valid, want invalid", label)
+		}
+	}
+}
+
+func TestListVersion(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		line string
+		want string
+		ok   bool
+	}{
+		{"# Version 2025061000, Last Updated Tue Jun 10 07:07:01 2025 UTC", "2025061000", true},
+		{"# Version 2025061000", "2025061000", true},
+		{"# Version ", "", false},
+		{"# Last Updated Tue Jun 10 07:07:01 2025 UTC", "", false},
+		{"COM", "", false},
+	} {
+		if got, ok := listVersion(tc.line); got != tc.want || ok != tc.ok {
+			t.Errorf("%q: got %q, %t, want %q, %t", tc.line, got, ok, tc.want, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const pidFilePerm = 0o644
+
+var errPIDFileInUse = errors.New("pidfile is in use by a running process")
+
+// writePIDFile writes the PID of the current process to path.
+// It refuses to do so if path already references another process which is still alive.
+func writePIDFile(path string) error {
+	if pid, err := readPIDFile(path); err == nil && pid != os.Getpid() && processAlive(pid) {
+		return fmt.Errorf("%w: %q references PID %d", errPIDFileInUse, path, pid)
+	}
+
+	//nolint:gosec // A pidfile is meant to be readable by others, e.g. the process supervisor
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), pidFilePerm); err != nil {
+		return fmt.Errorf("failed to write pidfile: %w", err)
+	}
+
+	return nil
+}
+
+func removePIDFile(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove pidfile: %w", err)
+	}
+
+	return nil
+}
+
+func readPIDFile(path string) (int, error) {
+	b, err := os.ReadFile(path) //nolint:gosec // The path is provided by the operator
+	if err != nil {
+		return 0, fmt.Errorf("failed to read pidfile: %w", err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse pidfile: %w", err)
+	}
+
+	return pid, nil
+}
+
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	// Signal 0 performs error checking only, no signal is actually sent.
+	// EPERM means the process exists but belongs to somebody else.
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,80 @@
+//go:build unix
+
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestPIDFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "tldwatch.pid")
+
+	if err := writePIDFile(path); err != nil {
+		t.Fatalf("failed to write pidfile: %v", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read pidfile: %v", err)
+	}
+	if want := strconv.Itoa(os.Getpid()) + "\n"; string(b) != want {
+		t.Errorf("got pidfile %q, want %q", b, want)
+	}
+
+	// Rewriting our own pidfile is fine
+	if err := writePIDFile(path); err != nil {
+		t.Fatalf("failed to rewrite pidfile: %v", err)
+	}
+
+	if err := removePIDFile(path); err != nil {
+		t.Fatalf("failed to remove pidfile: %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got error %v, want pidfile to be removed", err)
+	}
+	if err := removePIDFile(path); err != nil {
+		t.Errorf("failed to remove missing pidfile: %v", err)
+	}
+}
+
+func TestPIDFileInUse(t *testing.T) {
+	t.Parallel()
+
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep not available")
+	}
+
+	// The sleeping process plays the first instance
+	cmd := exec.CommandContext(t.Context(), sleep, "60") //nolint:gosec // Path looked up above
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start first instance: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "tldwatch.pid")
+	if err := os.WriteFile(path, []byte(strconv.Itoa(cmd.Process.Pid)+"\n"), pidFilePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writePIDFile(path); !errors.Is(err, errPIDFileInUse) {
+		t.Fatalf("got error %v, want %v", err, errPIDFileInUse)
+	}
+
+	// Once the first instance is gone, its stale pidfile is taken over
+	if err := cmd.Process.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	_ = cmd.Wait()
+
+	if err := writePIDFile(path); err != nil {
+		t.Fatalf("failed to take over stale pidfile: %v", err)
+	}
+	if pid, err := readPIDFile(path); err != nil || pid != os.Getpid() {
+		t.Errorf("got PID %d and error %v, want %d", pid, err, os.Getpid())
+	}
+}
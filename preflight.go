@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+const cmdPreflight = "preflight"
+
+var errPreflightFailed = errors.New("preflight failed")
+
+// preflightCheck is the outcome of a single preflight check.
+type preflightCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// preflight verifies that the source and the database can be reached, as well as the StatsD address and the NATS server if configured.
+// Unlike a regular run it doesn't download the source and never writes to the database.
+func preflight(ctx context.Context, l *slog.Logger, w io.Writer, cfg config) error {
+	checks := []preflightCheck{
+		newPreflightCheck("source", checkSource(ctx, cfg)),
+		newPreflightCheck("database", checkDatabase(ctx, l, cfg)),
+	}
+	if cfg.statsdAddr != "" {
+		_, err := net.ResolveUDPAddr("udp", cfg.statsdAddr)
+		checks = append(checks, newPreflightCheck("statsd", err))
+	}
+	if cfg.natsAddr != "" {
+		checks = append(checks, newPreflightCheck("nats", checkNATS(ctx, cfg)))
+	}
+
+	if err := writePreflight(w, cfg.format, checks); err != nil {
+		return fmt.Errorf("failed to print preflight result: %w", err)
+	}
+
+	for _, c := range checks {
+		if !c.OK {
+			return fmt.Errorf("%w: %s: %s", errPreflightFailed, c.Name, c.Error)
+		}
+	}
+
+	return nil
+}
+
+func newPreflightCheck(name string, err error) preflightCheck {
+	c := preflightCheck{
+		Name: name,
+		OK:   err == nil,
+	}
+	if err != nil {
+		c.Error = err.Error()
+	}
+	return c
+}
+
+// checkSource issues a HEAD request against a remote source, or makes sure a local one can be opened.
+func checkSource(ctx context.Context, cfg config) error {
+	if !isRemoteSource(cfg.source) {
+		f, err := openSourceFile(strings.TrimPrefix(cfg.source, fileURLPrefix))
+		if err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to close source file: %w", err)
+		}
+		return nil
+	}
+
+	if _, err := headSource(ctx, cfg.source, cfg.fetch); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// checkDatabase pings the database in read-only mode.
+// A database which doesn't exist yet is fine, it's created by the first run.
+func checkDatabase(ctx context.Context, l *slog.Logger, cfg config) error {
+	if _, err := os.Stat(cfg.sqliteFile); errors.Is(err, os.ErrNotExist) {
+		l.InfoContext(ctx, "database doesn't exist yet", "path", cfg.sqliteFile)
+		return nil
+	}
+
+	db, err := openDB(ctx, l, readOnlyDSN(cfg.sqliteFile), cfg.dbOpenRetries, cfg.dbOpenRetryDelay)
+	if err != nil {
+		return err
+	}
+
+	var hasSchema bool
+	err = db.QueryRowContext(ctx, sqliteHasSchemaStmt).Scan(&hasSchema)
+	if cerr := db.Close(); cerr != nil {
+		l.ErrorContext(ctx, fmt.Errorf("failed to close database: %w", cerr).Error())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check for database schema: %w", err)
+	}
+
+	return nil
+}
+
+// checkNATS connects to the NATS server and pings it without publishing anything.
+func checkNATS(ctx context.Context, cfg config) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	return pingNATS(ctx, cfg.natsAddr)
+}
+
+func writePreflight(w io.Writer, format string, checks []preflightCheck) error {
+	switch format {
+	case formatJSON, formatJSONObject:
+		return writeJSON(w, checks)
+	case formatHuman:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, c := range checks {
+			status := "ok"
+			if !c.OK {
+				status = "fail"
+			}
+			if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\n", status, c.Name, c.Error); err != nil {
+				return fmt.Errorf("failed to write: %w", err)
+			}
+		}
+		if err := tw.Flush(); err != nil {
+			return fmt.Errorf("failed to flush: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", errUnknownFormat, format)
+	}
+}
@@ -0,0 +1,64 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPreflight(t *testing.T) {
+	t.Parallel()
+
+	srv := newFixtureServer(t)
+	sqliteFile := filepath.Join(t.TempDir(), "db.sqlite")
+
+	preflightToChecks := func(t *testing.T, cfg config) ([]preflightCheck, error) {
+		t.Helper()
+
+		var buf bytes.Buffer
+		err := preflight(t.Context(), newTestLogger(), &buf, cfg)
+
+		var checks []preflightCheck
+		if jerr := json.Unmarshal(buf.Bytes(), &checks); jerr != nil {
+			t.Fatalf("failed to decode output %q: %v", buf.String(), jerr)
+		}
+		return checks, err
+	}
+
+	// A database which doesn't exist yet must not be created
+	checks, err := preflightToChecks(t, newTestConfig(srv.URL, sqliteFile))
+	if err != nil {
+		t.Fatalf("preflight failed: %v, %+v", err, checks)
+	}
+	if _, err := os.Stat(sqliteFile); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("preflight created the database: %v", err)
+	}
+
+	runToString(t, newTestConfig(srv.URL, sqliteFile))
+	if checks, err := preflightToChecks(t, newTestConfig(srv.URL, sqliteFile)); err != nil {
+		t.Fatalf("preflight failed on an existing database: %v, %+v", err, checks)
+	}
+
+	checks, err = preflightToChecks(t, newTestConfig(filepath.Join(t.TempDir(), "missing.txt"), sqliteFile))
+	if !errors.Is(err, errPreflightFailed) {
+		t.Fatalf("got error %v, want %v", err, errPreflightFailed)
+	}
+	if len(checks) != 2 || checks[0].OK || !checks[1].OK {
+		t.Fatalf("unexpected checks %+v", checks)
+	}
+
+	// The NATS server requires credentials which aren't configured
+	nats := natsTestServer{info: `{"auth_required":true}`, reply: "PONG\r\n", msgs: make(chan string, 1)}
+	cfg := newTestConfig(srv.URL, sqliteFile)
+	cfg.natsAddr = nats.start(t)
+	checks, err = preflightToChecks(t, cfg)
+	if !errors.Is(err, errPreflightFailed) {
+		t.Fatalf("got error %v, want %v", err, errPreflightFailed)
+	}
+	if len(checks) != 3 || checks[2].Name != "nats" || checks[2].OK {
+		t.Fatalf("unexpected checks %+v", checks)
+	}
+}
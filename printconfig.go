@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const cmdPrintConfig = "print-config"
+
+// redacted replaces secrets in the output of print-config.
+const redacted = "REDACTED"
+
+// effectiveConfig is the resolved configuration as printed by print-config.
+// Keys are named after the flags setting them, all of them are printed even if unset.
+type effectiveConfig struct {
+	Source         string   `json:"source"`
+	SQLiteFile     string   `json:"sqlite_file"`
+	Format         string   `json:"format"`
+	Outputs        []string `json:"out"`
+	RunLabel       string   `json:"run_label"`
+	RequestTimeout string   `json:"request_timeout"`
+	Debug          bool     `json:"debug"`
+
+	NoStdoutOnNoChange bool   `json:"no_stdout_on_nochange"`
+	OnlyInvalid        bool   `json:"only_invalid"`
+	PrintRemovedOnly   bool   `json:"print_removed_only"`
+	OutputKey          string `json:"output_key"`
+	Template           string `json:"template"`
+
+	Fetch effectiveFetchConfig `json:"fetch"`
+	Parse effectiveParseConfig `json:"parse"`
+
+	ChangelogFile   string `json:"changelog_file"`
+	Timezone        string `json:"timezone"`
+	StatusFile      string `json:"status_file"`
+	MetricsTextfile string `json:"metrics_textfile"`
+	StatsDAddr      string `json:"statsd_addr"`
+	NATSAddr        string `json:"nats_addr"`
+	NATSSubject     string `json:"nats_subject"`
+
+	DBOpenRetries    int    `json:"db_open_retries"`
+	DBOpenRetryDelay string `json:"db_open_retry_delay"`
+
+	AtomicSwap      bool   `json:"atomic_swap"`
+	Stream          bool   `json:"stream"`
+	HeadCheck       bool   `json:"head_check"`
+	Progress        bool   `json:"progress"`
+	ReconcileDelete bool   `json:"reconcile_delete"`
+	Seed            bool   `json:"seed"`
+	Conflict        string `json:"conflict"`
+	FailFast        bool   `json:"fail_fast"`
+
+	PIDFile         string `json:"pidfile"`
+	ExpandEnv       bool   `json:"expand_env"`
+	Mkdir           bool   `json:"mkdir"`
+	MkdirPerm       string `json:"mkdir_perm"`
+	NoLock          bool   `json:"no_lock"`
+	LockTimeout     string `json:"lock_timeout"`
+	Explain         string `json:"explain"`
+	BenchSize       int    `json:"bench_size"`
+	GeneratePackage string `json:"generate_package"`
+}
+
+type effectiveFetchConfig struct {
+	Header           http.Header `json:"header"`
+	AcceptFormats    []string    `json:"accept_formats"`
+	SaveRaw          string      `json:"save_raw"`
+	IPVersion        string      `json:"ip_version"`
+	HTTP2            bool        `json:"http2"`
+	IdleConnTimeout  string      `json:"idle_conn_timeout"`
+	TCPKeepAlive     string      `json:"tcp_keep_alive"`
+	StrictHTTPS      bool        `json:"strict_https"`
+	ResumeRetries    int         `json:"resume_retries"`
+	MaxResponseBytes int64       `json:"max_response_bytes"`
+	VerifyChecksum   bool        `json:"verify_checksum"`
+	UnixSocket       string      `json:"unix_socket"`
+}
+
+type effectiveParseConfig struct {
+	FormatIn      string            `json:"format_in"`
+	CSVColumn     string            `json:"csv_column"`
+	MaxLineSize   int               `json:"max_line_size"`
+	MinTLDLength  int               `json:"min_tld_length"`
+	MaxTLDLength  int               `json:"max_tld_length"`
+	OnlyCC        bool              `json:"only_cc"`
+	OnlyGeneric   bool              `json:"only_generic"`
+	KindOverride  map[string]string `json:"kind_override"`
+	IDNAVerify    bool              `json:"idna_verify"`
+	IDNAStrict    bool              `json:"idna_strict"`
+	NoIDNA        bool              `json:"no_idna"`
+	MultiPerLine  bool              `json:"multi_per_line"`
+	PreserveCase  bool              `json:"preserve_case"`
+	CommentPrefix []string          `json:"comment_prefix"`
+}
+
+// printConfig writes the resolved cfg as JSON to w, with credentials in the source URL, the NATS address and request headers redacted.
+func printConfig(w io.Writer, cfg config) error {
+	outputs := make([]string, 0, len(cfg.outputTargets()))
+	for _, t := range cfg.outputTargets() {
+		outputs = append(outputs, t.String())
+	}
+
+	commentPrefixes := cfg.parse.commentPrefixes
+	if len(commentPrefixes) == 0 {
+		commentPrefixes = []string{defaultCommentPrefix}
+	}
+
+	var tmpl string
+	if cfg.template != nil && cfg.template.Tree != nil {
+		tmpl = cfg.template.Root.String()
+	}
+
+	return writeJSON(w, effectiveConfig{
+		Source:         redactURL(cfg.source),
+		SQLiteFile:     cfg.sqliteFile,
+		Format:         cfg.format,
+		Outputs:        outputs,
+		RunLabel:       cfg.runLabel,
+		RequestTimeout: requestTimeout.String(),
+		Debug:          cfg.debug,
+
+		NoStdoutOnNoChange: cfg.noStdoutOnNoChange,
+		OnlyInvalid:        cfg.onlyInvalid,
+		PrintRemovedOnly:   cfg.printRemovedOnly,
+		OutputKey:          cfg.outputKey,
+		Template:           tmpl,
+
+		Fetch: effectiveFetchConfig{
+			Header:           redactHeader(cfg.fetch.header),
+			AcceptFormats:    cfg.acceptFormats,
+			SaveRaw:          cfg.fetch.saveRawPath,
+			IPVersion:        cfg.fetch.ipVersion,
+			HTTP2:            !cfg.fetch.disableHTTP2,
+			IdleConnTimeout:  cfg.fetch.idleConnTimeout.String(),
+			TCPKeepAlive:     cfg.fetch.tcpKeepAlive.String(),
+			StrictHTTPS:      cfg.fetch.strictHTTPS,
+			ResumeRetries:    cfg.fetch.resumeRetries,
+			MaxResponseBytes: cfg.fetch.maxResponseBytes,
+			VerifyChecksum:   cfg.fetch.verifyChecksum,
+			UnixSocket:       cfg.fetch.unixSocket,
+		},
+		Parse: effectiveParseConfig{
+			FormatIn:      cfg.parse.inputFormat,
+			CSVColumn:     cfg.parse.csvColumn,
+			MaxLineSize:   cfg.parse.maxLineSize,
+			MinTLDLength:  cfg.parse.minTLDLength,
+			MaxTLDLength:  cfg.parse.maxTLDLength,
+			OnlyCC:        cfg.parse.onlyCC,
+			OnlyGeneric:   cfg.parse.onlyGeneric,
+			KindOverride:  cfg.parse.kindOverrides,
+			IDNAVerify:    cfg.parse.idnaVerify,
+			IDNAStrict:    cfg.parse.idnaStrict,
+			NoIDNA:        cfg.parse.noIDNA,
+			MultiPerLine:  cfg.parse.multiPerLine,
+			PreserveCase:  cfg.parse.preserveCase,
+			CommentPrefix: commentPrefixes,
+		},
+
+		ChangelogFile:   cfg.changelogFile,
+		Timezone:        cfg.timezone.String(),
+		StatusFile:      cfg.statusFile,
+		MetricsTextfile: cfg.metricsTextfile,
+		StatsDAddr:      cfg.statsdAddr,
+		NATSAddr:        redactNATSAddr(cfg.natsAddr),
+		NATSSubject:     cfg.natsSubject,
+
+		DBOpenRetries:    cfg.dbOpenRetries,
+		DBOpenRetryDelay: cfg.dbOpenRetryDelay.String(),
+
+		AtomicSwap:      cfg.atomicSwap,
+		Stream:          cfg.stream,
+		HeadCheck:       cfg.headCheck,
+		Progress:        cfg.progress,
+		ReconcileDelete: cfg.reconcileDelete,
+		Seed:            cfg.seed,
+		Conflict:        cfg.conflict,
+		FailFast:        cfg.failFast,
+
+		PIDFile:         cfg.pidFile,
+		ExpandEnv:       cfg.expandEnv,
+		Mkdir:           cfg.mkdir,
+		MkdirPerm:       fmt.Sprintf("%#o", cfg.mkdirPerm),
+		NoLock:          cfg.noLock,
+		LockTimeout:     cfg.lockTimeout.String(),
+		Explain:         cfg.explainTLD,
+		BenchSize:       cfg.benchSize,
+		GeneratePackage: cfg.generatePackage,
+	})
+}
+
+// redactURL replaces the password in the user info of a remote source, other sources are returned as-is.
+func redactURL(source string) string {
+	if !isRemoteSource(source) {
+		return source
+	}
+	u, err := url.Parse(source)
+	if err != nil {
+		return source
+	}
+	return u.Redacted()
+}
+
+// redactNATSAddr replaces the password or token in a NATS address given as URL, see parseNATSAddr.
+// Addresses which can't be parsed are replaced entirely as they may hold credentials.
+func redactNATSAddr(addr string) string {
+	if !strings.Contains(addr, "://") {
+		return addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return redacted
+	}
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		} else {
+			u.User = url.User(redacted)
+		}
+	}
+	return u.String()
+}
+
+// redactHeader returns a copy of h with the values of headers which likely hold credentials replaced.
+func redactHeader(h http.Header) http.Header {
+	if len(h) == 0 {
+		return nil
+	}
+
+	out := make(http.Header, len(h))
+	for k, vs := range h {
+		if !isSecretHeader(k) {
+			out[k] = vs
+			continue
+		}
+		for range vs {
+			out[k] = append(out[k], redacted)
+		}
+	}
+	return out
+}
+
+func isSecretHeader(name string) bool {
+	switch http.CanonicalHeaderKey(name) {
+	case "Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie":
+		return true
+	}
+	lower := strings.ToLower(name)
+	for _, s := range []string{"token", "key", "secret", "password"} {
+		if strings.Contains(lower, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// progressInterval is the minimum time between two progress lines.
+const progressInterval = time.Second
+
+// progress reports the progress of storing TLDs as a single, continuously rewritten line.
+// All methods are no-ops on a nil *progress.
+type progress struct {
+	w     io.Writer
+	total int
+	start time.Time
+	last  time.Time
+}
+
+func newProgress(w io.Writer, total int) *progress {
+	return &progress{
+		w:     w,
+		total: total,
+		start: time.Now(),
+	}
+}
+
+// update reports that done out of the total TLDs have been stored.
+// Updates are rate-limited to one per progressInterval, except for the final one.
+func (p *progress) update(done int) {
+	if p == nil || p.total == 0 {
+		return
+	}
+
+	now := time.Now()
+	final := done >= p.total
+	if !final && now.Sub(p.last) < progressInterval {
+		return
+	}
+	p.last = now
+
+	var eta time.Duration
+	if done > 0 {
+		elapsed := now.Sub(p.start)
+		eta = time.Duration(float64(elapsed) / float64(done) * float64(p.total-done))
+	}
+
+	end := ""
+	if final {
+		end = "\n"
+	}
+	//nolint:errcheck // Progress is merely informational
+	fmt.Fprintf(
+		p.w,
+		"\rstored %d/%d TLDs (%d%%), ETA %s\x1b[K%s",
+		done, p.total,
+		done*100/p.total,
+		eta.Round(time.Second),
+		end,
+	)
+}
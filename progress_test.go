@@ -0,0 +1,35 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestProgress(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	p := newProgress(&buf, 4)
+
+	p.update(0)
+	if got, want := strings.Count(buf.String(), "\r"), 1; got != want {
+		t.Fatalf("got %d progress lines, want %d: %q", got, want, buf.String())
+	}
+
+	// Rate-limited
+	p.update(2)
+	if got, want := strings.Count(buf.String(), "\r"), 1; got != want {
+		t.Fatalf("got %d progress lines, want %d: %q", got, want, buf.String())
+	}
+
+	// The final update is never rate-limited
+	p.update(4)
+	if out := buf.String(); !strings.Contains(out, "stored 4/4 TLDs (100%)") || !strings.HasSuffix(out, "\n") {
+		t.Fatalf("unexpected final progress %q", out)
+	}
+
+	// A nil progress must be usable
+	var np *progress
+	np.update(1)
+}
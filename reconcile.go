@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+const cmdReconcile = "reconcile"
+
+// reconcile brings the database fully in line with the source.
+// Missing TLDs are inserted and, if cfg.reconcileDelete is set, extraneous ones are deleted, all in a single transaction.
+// Deleting is refused if the source is implausibly small, see checkPlausible.
+// Every change made is reported, the extraneous TLDs which are kept are only logged.
+func reconcile(
+	ctx context.Context,
+	l *slog.Logger,
+	w io.Writer,
+	cfg config,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	tlds, display, err := loadTLDs(ctx, requestTimeout, l, cfg.source, cfg.fetch, cfg.parse)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB(ctx, l, cfg.sqliteFile, cfg.dbOpenRetries, cfg.dbOpenRetryDelay)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			l.ErrorContext(ctx, fmt.Errorf("failed to close database: %w", err).Error())
+		}
+	}()
+
+	initialized, err := ensureSchema(ctx, l, db)
+	if err != nil {
+		return err
+	}
+
+	opts := storeOptions{ordinals: hasOrdinals(cfg), source: cfg.source, parse: cfg.parse}
+	stored, err := queryTLDs(ctx, db, sqliteLiveSelectStmt)
+	if err != nil {
+		return err
+	}
+	// TLDs left out by the filters are neither reported nor deleted
+	stored = opts.unfiltered(stored)
+	d := diffTLDs(stored, tlds)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			l.ErrorContext(ctx, fmt.Errorf("failed to roll back transaction: %w", err).Error())
+		}
+	}()
+
+	positions := make(map[tld]int, len(tlds))
+	for i, t := range tlds {
+		if _, ok := positions[t]; !ok {
+			positions[t] = i
+		}
+	}
+	for _, t := range d.added {
+		kind, overridden := opts.kind(t)
+		if _, err := tx.ExecContext(ctx, sqliteRestoreInsertStmt, t, opts.ordinal(positions[t]), opts.sourceValue(), kind, overridden); err != nil {
+			return fmt.Errorf("failed to insert %q: %w", t, err)
+		}
+	}
+	// TLDs kept although they're missing from the source must not look like they're still listed
+	seenAt := time.Now().UTC().Format(time.RFC3339)
+	for t := range positions {
+		if _, err := tx.ExecContext(ctx, sqliteLastSeenSetStmt, seenAt, t); err != nil {
+			return fmt.Errorf("failed to update last seen of %q: %w", t, err)
+		}
+	}
+
+	res := result{
+		RunLabel:      cfg.runLabel,
+		InitialImport: initialized,
+		Added:         d.added,
+		Removed:       make([]tld, 0),
+		display:       display,
+		outputKey:     cfg.outputKey,
+		template:      cfg.template,
+	}
+	if cfg.reconcileDelete {
+		if err := checkPlausible(len(stored), len(tlds)); err != nil {
+			return err
+		}
+		for _, t := range d.removed {
+			if _, err := tx.ExecContext(ctx, sqliteDeleteStmt, t); err != nil {
+				return fmt.Errorf("failed to delete %q: %w", t, err)
+			}
+		}
+		res.Removed = d.removed
+	} else if len(d.removed) > 0 {
+		l.WarnContext(
+			ctx,
+			"keeping TLDs missing from the source, use -reconcile-delete to delete them",
+			"tlds", d.removed,
+		)
+	}
+
+	res.extractChanged()
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	l.InfoContext(
+		ctx,
+		"reconciled database",
+		"inserted", len(res.Added),
+		"deleted", len(res.Removed),
+	)
+
+	if err := writeOutputs(w, cfg.outputTargets(), cfg.format, res); err != nil {
+		return fmt.Errorf("failed to print result: %w", err)
+	}
+
+	return nil
+}
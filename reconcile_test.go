@@ -0,0 +1,103 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestReconcile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cfg := newTestConfig(fixturePath, filepath.Join(dir, "db.sqlite"))
+	cfg.format = formatJSONObject
+	runToString(t, cfg)
+
+	// Simulate a manually edited database
+	db, err := openDB(t.Context(), newTestLogger(), cfg.sqliteFile, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("failed to close database: %v", err)
+		}
+	})
+	if _, err := db.ExecContext(t.Context(), sqliteDeleteStmt, "com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(t.Context(), sqliteInsertStmt, "bogus", nil, nil, kindGeneric, false); err != nil {
+		t.Fatal(err)
+	}
+
+	reconcileToResult := func(t *testing.T, cfg config) result {
+		t.Helper()
+
+		var buf bytes.Buffer
+		if err := reconcile(t.Context(), newTestLogger(), &buf, cfg); err != nil {
+			t.Fatalf("reconcile failed: %v", err)
+		}
+
+		var res result
+		if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
+			t.Fatalf("failed to decode output %q: %v", buf.String(), err)
+		}
+		return res
+	}
+
+	// Extraneous TLDs are kept by default
+	res := reconcileToResult(t, cfg)
+	if !slices.Equal(res.Added, []tld{"com"}) || len(res.Removed) != 0 {
+		t.Fatalf("got added %v and removed %v", res.Added, res.Removed)
+	}
+
+	cfg.reconcileDelete = true
+	res = reconcileToResult(t, cfg)
+	if len(res.Added) != 0 || !slices.Equal(res.Removed, []tld{"bogus"}) {
+		t.Fatalf("got added %v and removed %v", res.Added, res.Removed)
+	}
+
+	stored, err := queryTLDs(t.Context(), db, sqliteSelectStmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(stored), 42; got != want {
+		t.Fatalf("got %d stored TLDs, want %d", got, want)
+	}
+
+	// A broken source must not wipe the stored TLDs
+	cfg.source = filepath.Join(dir, "broken.txt")
+	if err := os.WriteFile(cfg.source, []byte("COM\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reconcile(t.Context(), newTestLogger(), io.Discard, cfg); !errors.Is(err, errImplausibleSource) {
+		t.Fatalf("got error %v, want %v", err, errImplausibleSource)
+	}
+
+	// TLDs left out by the filters aren't extraneous
+	cfg.source = fixturePath
+	cfg.parse.maxTLDLength = 2
+	res = reconcileToResult(t, cfg)
+	if len(res.Added) != 0 || len(res.Removed) != 0 {
+		t.Fatalf("got added %v and removed %v with a filter", res.Added, res.Removed)
+	}
+	if stored, err = queryTLDs(t.Context(), db, sqliteSelectStmt); err != nil || len(stored) != 42 {
+		t.Fatalf("got %d stored TLDs and error %v with a filter, want 42", len(stored), err)
+	}
+
+	cfg.parse.maxTLDLength = 0
+	cfg.parse.onlyCC = true
+	res = reconcileToResult(t, cfg)
+	if len(res.Added) != 0 || len(res.Removed) != 0 {
+		t.Fatalf("got added %v and removed %v with only-cc", res.Added, res.Removed)
+	}
+	if stored, err = queryTLDs(t.Context(), db, sqliteSelectStmt); err != nil || len(stored) != 42 {
+		t.Fatalf("got %d stored TLDs and error %v with only-cc, want 42", len(stored), err)
+	}
+}
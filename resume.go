@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errNotResumable = errors.New("download can't be resumed")
+
+// tempFile is a temporary file which is removed once closed.
+type tempFile struct {
+	*os.File
+}
+
+func (f tempFile) Close() error {
+	return errors.Join(f.File.Close(), os.Remove(f.Name()))
+}
+
+// downloadResumable copies the body of res, the response to a request for sourceURL, to a temporary file.
+// If the transfer breaks off and the server supports byte ranges, it's resumed from the last received offset
+// up to retries times instead of being restarted.
+// The returned file is positioned at its start and removed once closed.
+func downloadResumable(
+	ctx context.Context,
+	requestTimeout time.Duration,
+	l *slog.Logger,
+	sourceURL string,
+	fetchOpts fetchOptions,
+	res *http.Response,
+	retries int,
+) (io.ReadCloser, error) {
+	f, err := os.CreateTemp("", "tldwatch-source-*")
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to create download file: %w", err), res.Body.Close())
+	}
+	tf := tempFile{f}
+
+	if err := resumeInto(ctx, requestTimeout, l, sourceURL, fetchOpts, res, retries, f); err != nil {
+		return nil, errors.Join(err, tf.Close())
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to rewind download file: %w", err), tf.Close())
+	}
+
+	return tf, nil
+}
+
+func resumeInto(
+	ctx context.Context,
+	requestTimeout time.Duration,
+	l *slog.Logger,
+	sourceURL string,
+	fetchOpts fetchOptions,
+	res *http.Response,
+	retries int,
+	f *os.File,
+) error {
+	// If-Range makes sure that the remainder belongs to the same version of the source
+	validator := res.Header.Get("ETag")
+	if validator == "" {
+		validator = res.Header.Get("Last-Modified")
+	}
+	// Offsets of transparently decompressed bodies don't match those of the encoded representation
+	resumable := res.Header.Get("Accept-Ranges") == "bytes" && validator != "" && !res.Uncompressed
+
+	client := newHTTPClient(requestTimeout, fetchOpts)
+
+	var offset int64
+	for attempt := 0; ; attempt++ {
+		n, err := io.Copy(f, limitSize(res.Body, fetchOpts.maxResponseBytes, offset))
+		offset += n
+		if cerr := res.Body.Close(); cerr != nil {
+			l.ErrorContext(ctx, fmt.Errorf("failed to close body: %w", cerr).Error())
+		}
+		if err == nil {
+			return nil
+		}
+		if !resumable || attempt >= retries || ctx.Err() != nil || errors.Is(err, errTooLarge) {
+			return fmt.Errorf("failed to download source: %w", err)
+		}
+
+		l.InfoContext(
+			ctx,
+			"download broke off, resuming",
+			"err", err,
+			"offset", offset,
+			"attempt", attempt+1,
+		)
+
+		if res, err = requestRange(ctx, client, sourceURL, fetchOpts, offset, validator); err != nil {
+			return err
+		}
+		if res.StatusCode == http.StatusPartialContent {
+			continue
+		}
+
+		// The source changed in the meantime, hence start over
+		l.InfoContext(ctx, "source changed while downloading, restarting")
+		if err := f.Truncate(0); err != nil {
+			return errors.Join(fmt.Errorf("failed to truncate download file: %w", err), res.Body.Close())
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return errors.Join(fmt.Errorf("failed to rewind download file: %w", err), res.Body.Close())
+		}
+		offset = 0
+	}
+}
+
+// requestRange requests sourceURL from offset on, unless the source doesn't match validator anymore.
+// The response is either a 206 holding the remainder or a 200 holding the whole, new source.
+func requestRange(
+	ctx context.Context,
+	client *http.Client,
+	sourceURL string,
+	fetchOpts fetchOptions,
+	offset int64,
+	validator string,
+) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, vs := range fetchOpts.header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	req.Header.Set("If-Range", validator)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get: %w", err)
+	}
+	if res.StatusCode != http.StatusPartialContent && res.StatusCode != http.StatusOK {
+		return nil, errors.Join(fmt.Errorf("%w: %s", errNotResumable, res.Status), res.Body.Close())
+	}
+	if res.StatusCode == http.StatusPartialContent &&
+		!strings.HasPrefix(res.Header.Get("Content-Range"), "bytes "+strconv.FormatInt(offset, 10)+"-") {
+		return nil, errors.Join(
+			fmt.Errorf("%w: unexpected content range %q", errNotResumable, res.Header.Get("Content-Range")),
+			res.Body.Close(),
+		)
+	}
+
+	return res, nil
+}
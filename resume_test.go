@@ -0,0 +1,79 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadTLDsResume(t *testing.T) {
+	t.Parallel()
+
+	body, err := os.ReadFile(fixturePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		retries int
+		wantErr bool
+	}{
+		{"disabled", 0, true},
+		{"resumed", 1, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"v1"`)
+				if requests.Add(1) == 1 {
+					// Break off halfway through the first download
+					w.Header().Set("Accept-Ranges", "bytes")
+					w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+					if _, err := w.Write(body[:len(body)/2]); err != nil {
+						t.Errorf("failed to write: %v", err)
+					}
+					if err := http.NewResponseController(w).Flush(); err != nil {
+						t.Errorf("failed to flush: %v", err)
+					}
+					panic(http.ErrAbortHandler)
+				}
+				if r.Header.Get("Range") == "" {
+					t.Errorf("got request without range after the download broke off")
+				}
+				http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+			}))
+			t.Cleanup(srv.Close)
+
+			tlds, _, err := loadTLDs(t.Context(), requestTimeout, newTestLogger(), srv.URL, fetchOptions{
+				resumeRetries: tc.retries,
+			}, parseOptions{
+				maxLineSize: bufio.MaxScanTokenSize,
+			})
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("got error %v, want error: %t", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+
+			want, _, err := loadTLDs(t.Context(), requestTimeout, newTestLogger(), fixturePath, fetchOptions{}, parseOptions{
+				maxLineSize: bufio.MaxScanTokenSize,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tlds) != len(want) {
+				t.Fatalf("got %d TLDs, want %d", len(tlds), len(want))
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// schemaVersion is the version of the database schema this build expects.
+// It's stored in SQLite's user_version header field,
+// databases created before schema versioning was introduced report version 0.
+const schemaVersion = 8
+
+const (
+	cmdSchemaCheck    = "schema-check"
+	cmdPrintSchemaSQL = "print-schema-sql"
+)
+
+var (
+	errSchemaTooNew = errors.New("database schema is newer than supported, upgrade tldwatch")
+	errNoMigration  = errors.New("no schema migration")
+)
+
+// schemaMigration returns the statement which migrates the schema from version from to from+1.
+// An empty statement merely bumps the version.
+func schemaMigration(from int) (string, error) {
+	switch from {
+	case 0:
+		// The schema of version 1 is the unversioned one
+		return "", nil
+	case 1:
+		return sqliteMetaCreateStmt, nil
+	case 2:
+		return sqliteOrdinalAddStmt, nil
+	case 3:
+		return sqliteSourceAddStmt, nil
+	case 4:
+		return sqliteRemovedAtAddStmt, nil
+	case 5:
+		return sqliteSourceRenameStmt, nil
+	case 6:
+		return sqliteLastSeenAddStmt, nil
+	case 7:
+		return sqliteKindAddStmt, nil
+	default:
+		return "", fmt.Errorf("%w from version %d", errNoMigration, from)
+	}
+}
+
+// removedAtSchemaVersion is the first schema version which marks removed TLDs instead of deleting them.
+const removedAtSchemaVersion = 5
+
+// liveStmt returns live, which skips TLDs marked as removed, unless the schema of q predates marking them,
+// in which case it returns all. Read-only commands don't migrate the database, hence they may face an older schema.
+func liveStmt(ctx context.Context, q queryRower, live, all string) (string, error) {
+	v, err := readSchemaVersion(ctx, q)
+	if err != nil {
+		return "", err
+	}
+	if v < removedAtSchemaVersion {
+		return all, nil
+	}
+
+	return live, nil
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func readSchemaVersion(ctx context.Context, q queryRower) (int, error) {
+	var v int
+	if err := q.QueryRowContext(ctx, "pragma user_version").Scan(&v); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	return v, nil
+}
+
+// migrateSchema applies all pending schema migrations, each in its own transaction.
+// It refuses to touch a database whose schema is newer than schemaVersion.
+func migrateSchema(ctx context.Context, l *slog.Logger, db *sql.DB) error {
+	v, err := readSchemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if v > schemaVersion {
+		return fmt.Errorf("%w: got version %d, want at most %d", errSchemaTooNew, v, schemaVersion)
+	}
+
+	for ; v < schemaVersion; v++ {
+		if err := migrateSchemaStep(ctx, l, db, v); err != nil {
+			return err
+		}
+		l.InfoContext(
+			ctx,
+			"migrated database schema",
+			"from", v,
+			"to", v+1,
+		)
+	}
+
+	return nil
+}
+
+func migrateSchemaStep(ctx context.Context, l *slog.Logger, db *sql.DB, from int) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			l.ErrorContext(ctx, fmt.Errorf("failed to roll back transaction: %w", err).Error())
+		}
+	}()
+
+	stmt, err := schemaMigration(from)
+	if err != nil {
+		return err
+	}
+	if stmt != "" {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to migrate schema from version %d: %w", from, err)
+		}
+	}
+	// Pragmas don't support placeholders
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("pragma user_version = %d", from+1)); err != nil {
+		return fmt.Errorf("failed to set schema version: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// schemaStatus is the outcome of checking the database schema.
+type schemaStatus struct {
+	Current           int `json:"current"`
+	Expected          int `json:"expected"`
+	PendingMigrations int `json:"pending_migrations"`
+}
+
+// schemaCheck reports the schema version of the database and whether migrations are pending.
+// The database is opened read-only, pending migrations are applied by the next run.
+func schemaCheck(ctx context.Context, l *slog.Logger, w io.Writer, cfg config) error {
+	if _, err := os.Stat(cfg.sqliteFile); errors.Is(err, os.ErrNotExist) {
+		return errNotInitialized
+	}
+
+	db, err := openDB(ctx, l, readOnlyDSN(cfg.sqliteFile), cfg.dbOpenRetries, cfg.dbOpenRetryDelay)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			l.ErrorContext(ctx, fmt.Errorf("failed to close database: %w", err).Error())
+		}
+	}()
+
+	v, err := readSchemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	st := schemaStatus{
+		Current:           v,
+		Expected:          schemaVersion,
+		PendingMigrations: max(schemaVersion-v, 0),
+	}
+
+	if err := writeSchemaStatus(w, cfg.format, st); err != nil {
+		return fmt.Errorf("failed to print schema status: %w", err)
+	}
+
+	if v > schemaVersion {
+		return fmt.Errorf("%w: got version %d, want at most %d", errSchemaTooNew, v, schemaVersion)
+	}
+
+	return nil
+}
+
+func writeSchemaStatus(w io.Writer, format string, st schemaStatus) error {
+	switch format {
+	case formatJSON, formatJSONObject:
+		return writeJSON(w, st)
+	case formatHuman:
+		state := "up to date"
+		switch {
+		case st.Current > st.Expected:
+			state = "newer than supported"
+		case st.PendingMigrations > 0:
+			state = fmt.Sprintf("%d migrations pending", st.PendingMigrations)
+		}
+		if _, err := fmt.Fprintf(w, "schema version %d, expected %d: %s\n", st.Current, st.Expected, state); err != nil {
+			return fmt.Errorf("failed to write: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", errUnknownFormat, format)
+	}
+}
+
+// schemaSQL returns the SQL creating the current database schema from scratch,
+// i.e. the initial schema followed by all migrations, exactly as run by ensureSchema.
+func schemaSQL() (string, error) {
+	var b strings.Builder
+	writeStmt := func(stmt string) {
+		b.WriteString(dedent(stmt))
+	}
+
+	writeStmt(sqliteInitStmt)
+	writeStmt("pragma user_version = 1;")
+	for v := 1; v < schemaVersion; v++ {
+		stmt, err := schemaMigration(v)
+		if err != nil {
+			return "", err
+		}
+		if stmt != "" {
+			writeStmt(stmt)
+		}
+		writeStmt(fmt.Sprintf("pragma user_version = %d;", v+1))
+	}
+
+	return b.String(), nil
+}
+
+// printSchemaSQL writes the SQL creating the current database schema to w, see schemaSQL.
+func printSchemaSQL(w io.Writer) error {
+	stmts, err := schemaSQL()
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(w, stmts); err != nil {
+		return fmt.Errorf("failed to write: %w", err)
+	}
+
+	return nil
+}
+
+// dedent strips the surrounding whitespace of stmt as well as the indentation common to all of its lines.
+func dedent(stmt string) string {
+	lines := strings.Split(strings.Trim(stmt, "\n"), "\n")
+
+	indent := -1
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		n := len(line) - len(strings.TrimLeft(line, "\t "))
+		if indent < 0 || n < indent {
+			indent = n
+		}
+	}
+
+	var b strings.Builder
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		b.WriteString(line[max(indent, 0):])
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,194 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnsureSchemaVersion(t *testing.T) {
+	t.Parallel()
+
+	db := newMemoryDB(t)
+	ctx := t.Context()
+
+	// A database created before schema versioning
+	if _, err := db.ExecContext(ctx, sqliteInitStmt); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := readSchemaVersion(ctx, db); err != nil || v != 0 {
+		t.Fatalf("got version %d and error %v, want 0", v, err)
+	}
+
+	if _, err := ensureSchema(ctx, newTestLogger(), db); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	if v, err := readSchemaVersion(ctx, db); err != nil || v != schemaVersion {
+		t.Fatalf("got version %d and error %v, want %d", v, err, schemaVersion)
+	}
+
+	if _, err := db.ExecContext(ctx, "pragma user_version = 1000"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := syncWithDB(ctx, newTestLogger(), db, []tld{"com"}, storeOptions{}); !errors.Is(err, errSchemaTooNew) {
+		t.Fatalf("got error %v, want %v", err, errSchemaTooNew)
+	}
+}
+
+func TestMigrateLastSeen(t *testing.T) {
+	t.Parallel()
+
+	db := newMemoryDB(t)
+	ctx := t.Context()
+
+	// A database of the schema version before last_seen was introduced
+	if _, err := db.ExecContext(ctx, sqliteInitStmt); err != nil {
+		t.Fatal(err)
+	}
+	for v := 0; v < 6; v++ {
+		if err := migrateSchemaStep(ctx, newTestLogger(), db, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.ExecContext(ctx, "insert into tlds (tld, removed_at) values ('com', null), ('net', '2000-01-01T00:00:00Z')"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ensureSchema(ctx, newTestLogger(), db); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	var com, net string
+	if err := db.QueryRowContext(ctx, "select (select last_seen from tlds where tld = 'com'), (select last_seen from tlds where tld = 'net')").Scan(&com, &net); err != nil {
+		t.Fatal(err)
+	}
+	// Listed TLDs are backfilled with the time of the migration, removed ones with the time of their removal
+	if _, err := time.Parse(time.RFC3339, com); err != nil || com == "2000-01-01T00:00:00Z" {
+		t.Errorf("got last seen %q of com, want the time of the migration", com)
+	}
+	if net != "2000-01-01T00:00:00Z" {
+		t.Errorf("got last seen %q of net, want the time of its removal", net)
+	}
+}
+
+func TestMigrateKind(t *testing.T) {
+	t.Parallel()
+
+	db := newMemoryDB(t)
+	ctx := t.Context()
+
+	// A database of the schema version before the kind was stored
+	if _, err := db.ExecContext(ctx, sqliteInitStmt); err != nil {
+		t.Fatal(err)
+	}
+	for v := 0; v < 7; v++ {
+		if err := migrateSchemaStep(ctx, newTestLogger(), db, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.ExecContext(ctx, "insert into tlds (tld) values ('com'), ('uk'), ('xn--p1ai')"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ensureSchema(ctx, newTestLogger(), db); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	// Stored TLDs are classified by their form, no override is known for them
+	for tl, want := range map[string]string{"com": kindGeneric, "uk": kindCC, "xn--p1ai": kindGeneric} {
+		var (
+			kind       string
+			overridden bool
+		)
+		if err := db.QueryRowContext(ctx, "select kind, kind_overridden from tlds where tld = ?", tl).Scan(&kind, &overridden); err != nil {
+			t.Fatal(err)
+		}
+		if kind != want || overridden {
+			t.Errorf("got kind %q, overridden %t of %q, want %q, not overridden", kind, overridden, tl, want)
+		}
+	}
+}
+
+func TestSchemaCheck(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestConfig(fixturePath, filepath.Join(t.TempDir(), "db.sqlite"))
+
+	var buf bytes.Buffer
+	if err := schemaCheck(t.Context(), newTestLogger(), &buf, cfg); !errors.Is(err, errNotInitialized) {
+		t.Fatalf("got error %v, want %v", err, errNotInitialized)
+	}
+
+	runToString(t, cfg)
+	if err := schemaCheck(t.Context(), newTestLogger(), &buf, cfg); err != nil {
+		t.Fatalf("schema check failed: %v", err)
+	}
+
+	var st schemaStatus
+	if err := json.Unmarshal(buf.Bytes(), &st); err != nil {
+		t.Fatalf("failed to decode output %q: %v", buf.String(), err)
+	}
+	if want := (schemaStatus{Current: schemaVersion, Expected: schemaVersion}); st != want {
+		t.Fatalf("got status %+v, want %+v", st, want)
+	}
+}
+
+func TestSchemaSQL(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+
+	schema := func(t *testing.T, db *sql.DB) []string {
+		t.Helper()
+
+		rows, err := db.QueryContext(ctx, "select sql from sqlite_master where sql is not null order by name")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close() //nolint:errcheck // rows.Err is checked below
+
+		var stmts []string
+		for rows.Next() {
+			var stmt string
+			if err := rows.Scan(&stmt); err != nil {
+				t.Fatal(err)
+			}
+			// SQLite keeps the statements verbatim, including their indentation
+			stmts = append(stmts, strings.Join(strings.Fields(stmt), " "))
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+
+		v, err := readSchemaVersion(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(stmts, strconv.Itoa(v))
+	}
+
+	want := newMemoryDB(t)
+	if _, err := ensureSchema(ctx, newTestLogger(), want); err != nil {
+		t.Fatal(err)
+	}
+
+	stmts, err := schemaSQL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := newMemoryDB(t)
+	if _, err := got.ExecContext(ctx, stmts); err != nil {
+		t.Fatalf("failed to apply printed schema: %v\n%s", err, stmts)
+	}
+
+	if g, w := schema(t, got), schema(t, want); !slices.Equal(g, w) {
+		t.Fatalf("printed schema results in\n%q\nwant\n%q", g, w)
+	}
+}
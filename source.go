@@ -0,0 +1,572 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const fileURLPrefix = "file://"
+
+const (
+	rawPathTimestamp       = "{timestamp}"
+	rawPathTimestampLayout = "20060102T150405Z"
+	rawFilePerm            = 0o644
+)
+
+// maxRateLimitRetries is the number of times a rate-limited request is retried.
+const maxRateLimitRetries = 3
+
+// maxRedirects is the number of redirects followed, matching the default of http.Client.
+const maxRedirects = 10
+
+var (
+	errSourceOpen       = errors.New("failed to open source file")
+	errRateLimited      = errors.New("rate limited by source")
+	errUnexpectedStatus = errors.New("unexpected response status")
+	errInsecureSource   = errors.New("refusing to fetch the source over plain HTTP")
+	errTooManyRedirects = errors.New("too many redirects")
+	errTooLarge         = errors.New("source exceeds the maximum response size")
+)
+
+//nolint:gochecknoglobals // Byte slices can't be constants
+var gzipMagic = []byte{0x1f, 0x8b}
+
+type fetchOptions struct {
+	// header is added to the request for the source
+	header http.Header
+
+	// saveRawPath is where to keep a copy of the raw source, see rawPath
+	saveRawPath string
+
+	// ipVersion restricts connections to IPv4 or IPv6, see the ipVersion* constants
+	ipVersion string
+
+	// disableHTTP2 restricts requests to HTTP/1.1.
+	// idleConnTimeout and tcpKeepAlive default to the transport defaults if zero,
+	// a negative tcpKeepAlive disables TCP keep-alives.
+	disableHTTP2    bool
+	idleConnTimeout time.Duration
+	tcpKeepAlive    time.Duration
+
+	// strictHTTPS refuses plain HTTP sources, including redirects to them
+	strictHTTPS bool
+
+	// resumeRetries is the number of times a broken-off download is resumed using range requests, zero disables resuming.
+	// Resumable downloads are buffered in a temporary file before being parsed.
+	resumeRetries int
+
+	// verifyChecksum verifies remote sources against the checksum file published next to them, see fetchChecksum
+	verifyChecksum bool
+
+	// maxResponseBytes limits the size of the (decompressed) response body, zero disables the limit
+	maxResponseBytes int64
+
+	// unixSocket, if set, is dialed instead of the host of the source URL, which is still sent as the Host header
+	unixSocket string
+}
+
+// sizeLimitedReader reads from r, failing with errTooLarge once more than limit bytes were read in total.
+// Unlike io.LimitReader, hitting the limit is an error rather than a silent truncation, which would look like a shorter list.
+type sizeLimitedReader struct {
+	r     io.Reader
+	limit int64
+	read  int64
+}
+
+// limitSize limits r to limit bytes of which read were consumed already, see sizeLimitedReader.
+// A limit of zero or less returns r as-is.
+func limitSize(r io.Reader, limit, read int64) io.Reader {
+	if limit <= 0 {
+		return r
+	}
+	return &sizeLimitedReader{r: r, limit: limit, read: read}
+}
+
+func (l *sizeLimitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if over := l.read - l.limit; over > 0 {
+		return n - int(over), fmt.Errorf("%w of %d bytes", errTooLarge, l.limit)
+	}
+	return n, err //nolint:wrapcheck // io.EOF must not be wrapped
+}
+
+// validateSourceScheme makes sure that source isn't fetched over plain HTTP if strictHTTPS is set.
+// Local files are always fine.
+func validateSourceScheme(source string, strictHTTPS bool) error {
+	if strictHTTPS && strings.HasPrefix(strings.ToLower(source), "http://") {
+		return fmt.Errorf("%w: %q", errInsecureSource, source)
+	}
+
+	return nil
+}
+
+const (
+	ipVersionAuto = "auto"
+	ipVersion4    = "4"
+	ipVersion6    = "6"
+)
+
+// Transport defaults, matching those of http.DefaultTransport.
+const (
+	dialTimeout           = 30 * time.Second
+	dialKeepAlive         = 30 * time.Second
+	maxIdleConns          = 100
+	idleConnTimeout       = 90 * time.Second
+	tlsHandshakeTimeout   = 10 * time.Second
+	expectContinueTimeout = 1 * time.Second
+)
+
+var errUnknownIPVersion = errors.New("unknown IP version")
+
+func validateIPVersion(v string) error {
+	switch v {
+	case ipVersionAuto, ipVersion4, ipVersion6:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", errUnknownIPVersion, v)
+	}
+}
+
+func newHTTPClient(requestTimeout time.Duration, fetchOpts fetchOptions) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: cmp.Or(fetchOpts.tcpKeepAlive, dialKeepAlive),
+	}
+
+	dialContext := dialer.DialContext
+	if fetchOpts.ipVersion == ipVersion4 || fetchOpts.ipVersion == ipVersion6 {
+		dialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if network == "tcp" {
+				network += fetchOpts.ipVersion
+			}
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if fetchOpts.unixSocket != "" {
+		dialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", fetchOpts.unixSocket)
+		}
+		// The socket is the way to the source, a proxy would be dialed through it as well
+		proxy = nil
+	}
+
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialContext,
+		ForceAttemptHTTP2:     !fetchOpts.disableHTTP2,
+		MaxIdleConns:          maxIdleConns,
+		IdleConnTimeout:       cmp.Or(fetchOpts.idleConnTimeout, idleConnTimeout),
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ExpectContinueTimeout: expectContinueTimeout,
+	}
+	if fetchOpts.disableHTTP2 {
+		// A non-nil, empty map disables HTTP/2, see the net/http package docs
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	client := &http.Client{
+		Timeout:   requestTimeout,
+		Transport: transport,
+	}
+	if fetchOpts.strictHTTPS {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if req.URL.Scheme != "https" {
+				return fmt.Errorf("%w: redirect to %q", errInsecureSource, req.URL)
+			}
+			// Keep the default policy
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("%w: %d", errTooManyRedirects, len(via))
+			}
+			return nil
+		}
+	}
+
+	return client
+}
+
+// isRemoteSource reports whether source is fetched via HTTP rather than read from a local file.
+func isRemoteSource(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// loadTLDs loads and parses all TLDs from source.
+func loadTLDs(
+	ctx context.Context,
+	requestTimeout time.Duration,
+	l *slog.Logger,
+	source string,
+	fetchOpts fetchOptions,
+	opts parseOptions,
+) ([]tld, map[tld]string, error) {
+	c := newTLDCollector(opts.preserveCase)
+	if err := streamTLDs(ctx, requestTimeout, l, source, fetchOpts, opts, c.add); err != nil {
+		return nil, nil, err
+	}
+
+	return c.tlds, c.display, nil
+}
+
+// streamTLDs loads TLDs from source and passes each of them to emit, see scanTLDs.
+func streamTLDs(
+	ctx context.Context,
+	requestTimeout time.Duration,
+	l *slog.Logger,
+	source string,
+	fetchOpts fetchOptions,
+	opts parseOptions,
+	emit func(t tld, orig string) error,
+) error {
+	if fetchOpts.verifyChecksum && opts.streaming {
+		return errChecksumStream
+	}
+
+	return readSource(ctx, requestTimeout, l, source, fetchOpts, opts, func(r io.Reader) error {
+		return scanTLDs(ctx, l, r, opts, emit)
+	})
+}
+
+// readSource fetches or opens source and passes the decoded list to read.
+// The source is closed once read returns.
+func readSource(
+	ctx context.Context,
+	requestTimeout time.Duration,
+	l *slog.Logger,
+	source string,
+	fetchOpts fetchOptions,
+	opts parseOptions,
+	read func(r io.Reader) error,
+) error {
+	if err := validateSourceScheme(source, fetchOpts.strictHTTPS); err != nil {
+		return err
+	}
+
+	var (
+		rc          io.ReadCloser
+		contentType string
+	)
+	if isRemoteSource(source) {
+		res, err := fetchSource(ctx, requestTimeout, l, source, fetchOpts)
+		if err != nil {
+			return err
+		}
+		rc, contentType = res.Body, res.Header.Get("Content-Type")
+		if fetchOpts.resumeRetries > 0 {
+			if rc, err = downloadResumable(ctx, requestTimeout, l, source, fetchOpts, res, fetchOpts.resumeRetries); err != nil {
+				return err
+			}
+		}
+	} else {
+		f, err := openSourceFile(strings.TrimPrefix(source, fileURLPrefix))
+		if err != nil {
+			return err
+		}
+		rc = f
+	}
+	defer func() {
+		if err := rc.Close(); err != nil {
+			l.ErrorContext(ctx, fmt.Errorf("failed to close source: %w", err).Error())
+		}
+	}()
+
+	r := io.Reader(rc)
+	if isRemoteSource(source) {
+		r = limitSize(r, fetchOpts.maxResponseBytes, 0)
+
+		if fetchOpts.verifyChecksum {
+			c, err := fetchChecksum(ctx, requestTimeout, l, source, fetchOpts)
+			if err != nil {
+				return err
+			}
+			if r, err = verifyChecksum(r, c); err != nil {
+				return err
+			}
+			l.DebugContext(
+				ctx,
+				"verified source checksum",
+				"url", redactURL(c.url),
+			)
+		}
+	}
+	if fetchOpts.saveRawPath != "" {
+		f, err := saveRaw(r, rawPath(fetchOpts.saveRawPath, time.Now()))
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				l.ErrorContext(ctx, fmt.Errorf("failed to close raw copy: %w", err).Error())
+			}
+		}()
+		l.DebugContext(
+			ctx,
+			"saved raw source",
+			"path", f.Name(),
+		)
+		// Continue with the saved copy so that it's exactly what got parsed
+		r = f
+	}
+
+	if !isRemoteSource(source) {
+		var err error
+		if r, err = decodeSourceFile(r); err != nil {
+			return err
+		}
+	}
+
+	if inputFormatFor(opts.inputFormat, source, contentType) == inputFormatCSV {
+		var err error
+		if r, err = decodeCSV(r, opts.csvColumn); err != nil {
+			return err
+		}
+	}
+
+	return read(r)
+}
+
+func fetchSource(
+	ctx context.Context,
+	requestTimeout time.Duration,
+	l *slog.Logger,
+	sourceURL string,
+	fetchOpts fetchOptions,
+) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, vs := range fetchOpts.header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+
+	client := newHTTPClient(requestTimeout, fetchOpts)
+
+	for attempt := 0; ; attempt++ {
+		l.DebugContext(
+			ctx,
+			"sending request",
+			"method", req.Method,
+			"url", req.URL.Redacted(),
+			"header", redactHeader(req.Header),
+		)
+
+		res, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get: %w", err)
+		}
+
+		l.DebugContext(
+			ctx,
+			"received response",
+			"status", res.Status,
+			"proto", res.Proto,
+			"header", redactHeader(res.Header),
+		)
+
+		if res.StatusCode != http.StatusTooManyRequests {
+			// Error pages must never reach the parser, they'd be stored as TLDs
+			if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+				return nil, errors.Join(fmt.Errorf("%w: %s", errUnexpectedStatus, res.Status), res.Body.Close())
+			}
+			return res, nil
+		}
+
+		if err := res.Body.Close(); err != nil {
+			l.ErrorContext(ctx, fmt.Errorf("failed to close body: %w", err).Error())
+		}
+
+		wait, ok := parseRetryAfter(res.Header.Get("Retry-After"), time.Now())
+		if !ok || attempt >= maxRateLimitRetries {
+			return nil, fmt.Errorf("%w: %s", errRateLimited, res.Status)
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return nil, fmt.Errorf("%w: retry after %s exceeds the timeout", errRateLimited, wait)
+		}
+
+		l.InfoContext(
+			ctx,
+			"rate limited by source, retrying",
+			"delay", wait,
+		)
+		if err := sleepContext(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// headSource issues a HEAD request against the remote source and returns the response header.
+func headSource(ctx context.Context, source string, fetchOpts fetchOptions) (http.Header, error) {
+	if err := validateSourceScheme(source, fetchOpts.strictHTTPS); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, source, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, vs := range fetchOpts.header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+
+	res, err := newHTTPClient(requestTimeout, fetchOpts).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to perform request: %w", err)
+	}
+	if err := res.Body.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close response body: %w", err)
+	}
+	if res.StatusCode >= http.StatusBadRequest {
+		return nil, fmt.Errorf("%w: %s", errUnexpectedStatus, res.Status)
+	}
+
+	return res.Header, nil
+}
+
+// parseRetryAfter parses the value of a Retry-After header, either in its delay-seconds or its HTTP-date form.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+
+	return max(t.Sub(now), 0), true
+}
+
+// rawPath expands the {timestamp} placeholder in path.
+func rawPath(path string, now time.Time) string {
+	return strings.ReplaceAll(path, rawPathTimestamp, now.UTC().Format(rawPathTimestampLayout))
+}
+
+// saveRaw copies r to a new file at path and returns that file, positioned at its start.
+func saveRaw(r io.Reader, path string) (*os.File, error) {
+	//nolint:gosec // The path is provided by the operator
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, rawFilePerm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create raw copy: %w", err)
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		return nil, errors.Join(
+			fmt.Errorf("failed to save raw copy: %w", err),
+			f.Close(),
+		)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Join(
+			fmt.Errorf("failed to rewind raw copy: %w", err),
+			f.Close(),
+		)
+	}
+
+	return f, nil
+}
+
+// openSourceFile opens the local source file at path.
+// All errors wrap errSourceOpen so that they can be told apart from network errors.
+func openSourceFile(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path) //nolint:gosec // The path is provided by the operator
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errSourceOpen, err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, errors.Join(
+			fmt.Errorf("%w: %w", errSourceOpen, err),
+			f.Close(),
+		)
+	}
+	if fi.IsDir() {
+		return nil, errors.Join(
+			fmt.Errorf("%w: %q is a directory", errSourceOpen, path),
+			f.Close(),
+		)
+	}
+
+	return f, nil
+}
+
+// decodeSourceFile transparently decompresses gzipped source files and
+// converts our own JSON output back into the plain-text list format.
+// Both are detected by content rather than by file extension.
+func decodeSourceFile(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	if magic, err := br.Peek(len(gzipMagic)); err == nil && bytes.Equal(magic, gzipMagic) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzipped source: %w", err)
+		}
+		br = bufio.NewReader(zr)
+	}
+
+	var first byte
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			// Empty or unreadable, let the parser deal with it
+			return br, nil //nolint:nilerr // See above
+		}
+		if !unicode.IsSpace(rune(b[0])) {
+			first = b[0]
+			break
+		}
+		if _, err := br.ReadByte(); err != nil {
+			return nil, fmt.Errorf("failed to read source: %w", err)
+		}
+	}
+
+	var tlds []string
+	switch first {
+	case '[':
+		if err := json.NewDecoder(br).Decode(&tlds); err != nil {
+			return nil, fmt.Errorf("failed to decode JSON source: %w", err)
+		}
+	case '{':
+		var res struct {
+			Added []string `json:"added"`
+		}
+		if err := json.NewDecoder(br).Decode(&res); err != nil {
+			return nil, fmt.Errorf("failed to decode JSON source: %w", err)
+		}
+		tlds = res.Added
+	default:
+		return br, nil
+	}
+
+	return strings.NewReader(strings.Join(tlds, "\n")), nil
+}
@@ -0,0 +1,442 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadTLDsSourceFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	for _, tc := range []struct {
+		name    string
+		source  string
+		wantErr error
+	}{
+		{"file", fixturePath, nil},
+		{"file-url", fileURLPrefix + fixturePath, nil},
+		{"missing", filepath.Join(dir, "missing.txt"), errSourceOpen},
+		{"directory", dir, errSourceOpen},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			tlds, _, err := loadTLDs(t.Context(), requestTimeout, newTestLogger(), tc.source, fetchOptions{}, parseOptions{
+				maxLineSize: bufio.MaxScanTokenSize,
+			})
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tc.wantErr)
+			}
+			if tc.wantErr == nil && len(tlds) == 0 {
+				t.Fatal("got no TLDs")
+			}
+		})
+	}
+}
+
+func TestDecodeSourceFile(t *testing.T) {
+	t.Parallel()
+
+	gzipped := func(s string) string {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write([]byte(s)); err != nil {
+			t.Fatalf("failed to gzip: %v", err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatalf("failed to gzip: %v", err)
+		}
+		return buf.String()
+	}
+
+	const (
+		text       = "# Version 2025061000\nCOM\nXN--P1AI\n"
+		jsonArray  = `["com","рф"]` + "\n"
+		jsonObject = `{"initial_import":false,"added":["com","рф"]}` + "\n"
+	)
+
+	for _, tc := range []struct {
+		name string
+		src  string
+	}{
+		{"text", text},
+		{"text-gzip", gzipped(text)},
+		{"json", "\n  " + jsonArray},
+		{"json-gzip", gzipped(jsonArray)},
+		{"json-object", jsonObject},
+		{"json-object-gzip", gzipped(jsonObject)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r, err := decodeSourceFile(strings.NewReader(tc.src))
+			if err != nil {
+				t.Fatalf("failed to decode: %v", err)
+			}
+
+			tlds, _, err := parseTLDs(t.Context(), newTestLogger(), r, parseOptions{
+				maxLineSize: bufio.MaxScanTokenSize,
+			})
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+			if want := []tld{"com", "рф"}; !slices.Equal(tlds, want) {
+				t.Fatalf("got TLDs %v, want %v", tlds, want)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, time.June, 10, 7, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		v      string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"0", 0, true},
+		{"-1", 0, false},
+		{"Tue, 10 Jun 2025 07:00:30 GMT", 30 * time.Second, true},
+		{"Tue, 10 Jun 2025 06:59:00 GMT", 0, true},
+		{"soon", 0, false},
+	} {
+		got, ok := parseRetryAfter(tc.v, now)
+		if got != tc.want || ok != tc.wantOK {
+			t.Errorf("parseRetryAfter(%q) = %s, %t, want %s, %t", tc.v, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
+
+func TestLoadTLDsRateLimited(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		http.ServeFile(w, r, fixturePath)
+	}))
+	t.Cleanup(srv.Close)
+
+	tlds, _, err := loadTLDs(t.Context(), requestTimeout, newTestLogger(), srv.URL, fetchOptions{}, parseOptions{
+		maxLineSize: bufio.MaxScanTokenSize,
+	})
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	if len(tlds) == 0 {
+		t.Fatal("got no TLDs")
+	}
+	if got, want := requests.Load(), int32(2); got != want {
+		t.Fatalf("got %d requests, want %d", got, want)
+	}
+}
+
+func TestLoadTLDsUnexpectedStatus(t *testing.T) {
+	t.Parallel()
+
+	for _, status := range []int{http.StatusNotFound, http.StatusServiceUnavailable} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			http.Error(w, http.StatusText(status), status)
+		}))
+		t.Cleanup(srv.Close)
+
+		tlds, _, err := loadTLDs(t.Context(), requestTimeout, newTestLogger(), srv.URL, fetchOptions{}, parseOptions{
+			maxLineSize: bufio.MaxScanTokenSize,
+		})
+		if !errors.Is(err, errUnexpectedStatus) {
+			t.Errorf("%d: got TLDs %v and error %v, want %v", status, tlds, err, errUnexpectedStatus)
+		}
+	}
+}
+
+func TestLoadTLDsHeader(t *testing.T) {
+	t.Parallel()
+
+	got := make(chan http.Header, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Clone()
+		http.ServeFile(w, r, fixturePath)
+	}))
+	t.Cleanup(srv.Close)
+
+	// Collected like repeated -header flags
+	header := make(headerFlag)
+	for _, s := range []string{"Authorization: Bearer t0ken", "X-Trace: a", "x-trace:b"} {
+		if err := header.Set(s); err != nil {
+			t.Fatalf("failed to set header %q: %v", s, err)
+		}
+	}
+
+	if _, _, err := loadTLDs(t.Context(), requestTimeout, newTestLogger(), srv.URL, fetchOptions{
+		header: http.Header(header),
+	}, parseOptions{maxLineSize: bufio.MaxScanTokenSize}); err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+
+	h := <-got
+	if v := h.Get("Authorization"); v != "Bearer t0ken" {
+		t.Errorf("got Authorization %q, want %q", v, "Bearer t0ken")
+	}
+	if vs, want := h.Values("X-Trace"), []string{"a", "b"}; !slices.Equal(vs, want) {
+		t.Errorf("got X-Trace %q, want %q", vs, want)
+	}
+}
+
+func TestLoadTLDsDebugLogRedacted(t *testing.T) {
+	t.Parallel()
+
+	srv := newFixtureServer(t)
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.User = url.UserPassword("alice", "s3cret")
+
+	var buf bytes.Buffer
+	l := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	if _, _, err := loadTLDs(t.Context(), requestTimeout, l, u.String(), fetchOptions{
+		header: http.Header{"Authorization": {"Bearer t0ken"}},
+	}, parseOptions{maxLineSize: bufio.MaxScanTokenSize}); err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+
+	if !strings.Contains(buf.String(), "sending request") {
+		t.Fatalf("got log %q, want the request to be logged", buf.String())
+	}
+	for _, secret := range []string{"s3cret", "t0ken"} {
+		if strings.Contains(buf.String(), secret) {
+			t.Errorf("log leaks %q: %s", secret, buf.String())
+		}
+	}
+}
+
+func TestLoadTLDsMaxResponseBytes(t *testing.T) {
+	t.Parallel()
+
+	srv := newFixtureServer(t)
+	fi, err := os.Stat(fixturePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := parseOptions{maxLineSize: bufio.MaxScanTokenSize}
+
+	if _, _, err := loadTLDs(t.Context(), requestTimeout, newTestLogger(), srv.URL, fetchOptions{
+		maxResponseBytes: fi.Size() - 1,
+	}, opts); !errors.Is(err, errTooLarge) {
+		t.Fatalf("got error %v, want %v", err, errTooLarge)
+	}
+
+	tlds, _, err := loadTLDs(t.Context(), requestTimeout, newTestLogger(), srv.URL, fetchOptions{
+		maxResponseBytes: fi.Size(),
+	}, opts)
+	if err != nil {
+		t.Fatalf("failed to load a source of exactly the maximum size: %v", err)
+	}
+	if len(tlds) == 0 {
+		t.Fatal("got no TLDs")
+	}
+}
+
+func TestLoadTLDsIPVersion(t *testing.T) {
+	t.Parallel()
+
+	// httptest servers listen on 127.0.0.1
+	srv := newFixtureServer(t)
+
+	for _, tc := range []struct {
+		ipVersion string
+		wantErr   bool
+	}{
+		{ipVersionAuto, false},
+		{ipVersion4, false},
+		{ipVersion6, true},
+	} {
+		t.Run(tc.ipVersion, func(t *testing.T) {
+			t.Parallel()
+
+			_, _, err := loadTLDs(t.Context(), requestTimeout, newTestLogger(), srv.URL, fetchOptions{
+				ipVersion: tc.ipVersion,
+			}, parseOptions{
+				maxLineSize: bufio.MaxScanTokenSize,
+			})
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("got error %v, want error: %t", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadTLDsUnixSocket(t *testing.T) {
+	t.Parallel()
+
+	// t.TempDir may exceed the maximum length of socket paths
+	dir, err := os.MkdirTemp("", "tldwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("failed to remove socket dir: %v", err)
+		}
+	})
+
+	ln, err := net.Listen("unix", filepath.Join(dir, "source.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "tlds.example" {
+			http.Error(w, "unexpected host "+r.Host, http.StatusBadRequest)
+			return
+		}
+		http.ServeFile(w, r, fixturePath)
+	}))
+	srv.Listener = ln
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	tlds, _, err := loadTLDs(t.Context(), requestTimeout, newTestLogger(), "http://tlds.example/tlds.txt", fetchOptions{
+		unixSocket: ln.Addr().String(),
+	}, parseOptions{
+		maxLineSize: bufio.MaxScanTokenSize,
+	})
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	if len(tlds) == 0 {
+		t.Fatal("got no TLDs")
+	}
+}
+
+func TestLoadTLDsSaveRaw(t *testing.T) {
+	t.Parallel()
+
+	srv := newFixtureServer(t)
+	dir := t.TempDir()
+
+	tlds, _, err := loadTLDs(t.Context(), requestTimeout, newTestLogger(), srv.URL, fetchOptions{
+		saveRawPath: filepath.Join(dir, "tlds-"+rawPathTimestamp+".txt"),
+	}, parseOptions{
+		maxLineSize: bufio.MaxScanTokenSize,
+	})
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "tlds-*.txt"))
+	if err != nil || len(matches) != 1 {
+		t.Fatalf("got raw copies %v (%v), want exactly one", matches, err)
+	}
+
+	saved, err := os.ReadFile(matches[0])
+	if err != nil {
+		t.Fatalf("failed to read raw copy: %v", err)
+	}
+	want, err := os.ReadFile(fixturePath)
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	if !bytes.Equal(saved, want) {
+		t.Fatal("raw copy differs from the served source")
+	}
+
+	// The saved copy can be replayed as a source
+	replayed, _, err := loadTLDs(t.Context(), requestTimeout, newTestLogger(), matches[0], fetchOptions{}, parseOptions{
+		maxLineSize: bufio.MaxScanTokenSize,
+	})
+	if err != nil {
+		t.Fatalf("failed to replay: %v", err)
+	}
+	if !slices.Equal(replayed, tlds) {
+		t.Fatalf("got replayed TLDs %v, want %v", replayed, tlds)
+	}
+}
+
+func TestNewHTTPClientTransport(t *testing.T) {
+	t.Parallel()
+
+	transport := func(opts fetchOptions) *http.Transport {
+		t.Helper()
+
+		tr, ok := newHTTPClient(time.Second, opts).Transport.(*http.Transport)
+		if !ok {
+			t.Fatal("client doesn't use an *http.Transport")
+		}
+		return tr
+	}
+
+	tr := transport(fetchOptions{})
+	if !tr.ForceAttemptHTTP2 || tr.TLSNextProto != nil {
+		t.Error("HTTP/2 is not enabled by default")
+	}
+	if got, want := tr.IdleConnTimeout, idleConnTimeout; got != want {
+		t.Errorf("got idle conn timeout %s, want the default %s", got, want)
+	}
+
+	tr = transport(fetchOptions{
+		disableHTTP2:    true,
+		idleConnTimeout: time.Minute,
+	})
+	if tr.ForceAttemptHTTP2 || tr.TLSNextProto == nil {
+		t.Error("HTTP/2 is not disabled")
+	}
+	if got, want := tr.IdleConnTimeout, time.Minute; got != want {
+		t.Errorf("got idle conn timeout %s, want %s", got, want)
+	}
+}
+
+func TestLoadTLDsStrictHTTPS(t *testing.T) {
+	t.Parallel()
+
+	srv := newFixtureServer(t)
+	opts := parseOptions{maxLineSize: bufio.MaxScanTokenSize}
+
+	_, _, err := loadTLDs(t.Context(), time.Second, newTestLogger(), srv.URL, fetchOptions{strictHTTPS: true}, opts)
+	if !errors.Is(err, errInsecureSource) {
+		t.Fatalf("got error %v, want %v", err, errInsecureSource)
+	}
+
+	// Local files are always allowed
+	if _, _, err := loadTLDs(t.Context(), time.Second, newTestLogger(), fixturePath, fetchOptions{strictHTTPS: true}, opts); err != nil {
+		t.Fatalf("failed to load local file: %v", err)
+	}
+
+	// Redirects to plain HTTP are refused as well
+	client := newHTTPClient(time.Second, fetchOptions{strictHTTPS: true})
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, "https://example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirect, err := http.NewRequestWithContext(t.Context(), http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CheckRedirect(redirect, []*http.Request{req}); !errors.Is(err, errInsecureSource) {
+		t.Fatalf("got error %v for a redirect to plain HTTP, want %v", err, errInsecureSource)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const statsdPrefix = "tldwatch."
+
+// statsdTagSpecials have a meaning in the DogStatsD datagram format, hence they can't be part of a tag value.
+const statsdTagSpecials = "|,:#@"
+
+var errInvalidRunLabel = errors.New("invalid run label")
+
+// runMetrics are the metric points collected during a run.
+type runMetrics struct {
+	total         int
+	added         int
+	removed       int
+	fetchDuration time.Duration
+
+	// runLabel is sent as a DogStatsD-style tag if set
+	runLabel string
+}
+
+// validateRunLabel makes sure label can be sent as a StatsD tag without corrupting the datagram.
+// Any label is fine unless StatsD is enabled by statsdAddr, the other places it ends up in escape it.
+func validateRunLabel(label, statsdAddr string) error {
+	if statsdAddr == "" {
+		return nil
+	}
+	if strings.ContainsAny(label, statsdTagSpecials) || strings.ContainsFunc(label, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r)
+	}) {
+		return fmt.Errorf("%w: %q must not contain whitespace or any of %q", errInvalidRunLabel, label, statsdTagSpecials)
+	}
+
+	return nil
+}
+
+// sendStatsD sends m to the StatsD server at addr.
+// All metrics are sent in a single UDP datagram.
+func sendStatsD(ctx context.Context, addr string, m runMetrics) error {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to dial StatsD: %w", err)
+	}
+
+	var tags string
+	if m.runLabel != "" {
+		tags = "|#run_label:" + m.runLabel
+	}
+
+	lines := []string{
+		fmt.Sprintf("%stlds.total:%d|g%s", statsdPrefix, m.total, tags),
+		fmt.Sprintf("%stlds.added:%d|c%s", statsdPrefix, m.added, tags),
+		fmt.Sprintf("%stlds.removed:%d|c%s", statsdPrefix, m.removed, tags),
+		fmt.Sprintf("%sfetch.duration:%d|ms%s", statsdPrefix, m.fetchDuration.Milliseconds(), tags),
+	}
+	if _, err := conn.Write([]byte(strings.Join(lines, "\n"))); err != nil {
+		return errors.Join(
+			fmt.Errorf("failed to send to StatsD: %w", err),
+			conn.Close(),
+		)
+	}
+
+	if err := conn.Close(); err != nil {
+		return fmt.Errorf("failed to close StatsD connection: %w", err)
+	}
+
+	return nil
+}
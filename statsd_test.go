@@ -0,0 +1,83 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSendStatsD(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name     string
+		runLabel string
+		want     string
+	}{
+		{
+			"unlabeled", "",
+			"tldwatch.tlds.total:1500|g\ntldwatch.tlds.added:2|c\ntldwatch.tlds.removed:1|c\ntldwatch.fetch.duration:250|ms",
+		},
+		{
+			"labeled", "prod-eu",
+			"tldwatch.tlds.total:1500|g|#run_label:prod-eu\ntldwatch.tlds.added:2|c|#run_label:prod-eu\ntldwatch.tlds.removed:1|c|#run_label:prod-eu\ntldwatch.fetch.duration:250|ms|#run_label:prod-eu",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("failed to listen: %v", err)
+			}
+			t.Cleanup(func() {
+				if err := conn.Close(); err != nil {
+					t.Errorf("failed to close listener: %v", err)
+				}
+			})
+
+			if err := sendStatsD(t.Context(), conn.LocalAddr().String(), runMetrics{
+				total:         1500,
+				added:         2,
+				removed:       1,
+				fetchDuration: 250 * time.Millisecond,
+				runLabel:      tc.runLabel,
+			}); err != nil {
+				t.Fatalf("failed to send: %v", err)
+			}
+
+			buf := make([]byte, 1024)
+			if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+				t.Fatalf("failed to set read deadline: %v", err)
+			}
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				t.Fatalf("failed to read: %v", err)
+			}
+
+			if got := string(buf[:n]); got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateRunLabel(t *testing.T) {
+	t.Parallel()
+
+	for _, label := range []string{"", "prod", "eu-west-1", "staging/blue"} {
+		if err := validateRunLabel(label, "localhost:8125"); err != nil {
+			t.Errorf("%q: got error %v, want nil", label, err)
+		}
+	}
+	for _, label := range []string{"a|b", "a,b", "env:prod", "prod\nfoo:1|c", "prod eu"} {
+		if err := validateRunLabel(label, "localhost:8125"); !errors.Is(err, errInvalidRunLabel) {
+			t.Errorf("%q: got error %v, want %v", label, err, errInvalidRunLabel)
+		}
+		// The label isn't sent anywhere it could corrupt
+		if err := validateRunLabel(label, ""); err != nil {
+			t.Errorf("%q: got error %v without StatsD, want nil", label, err)
+		}
+	}
+}
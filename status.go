@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const statusFilePerm = 0o644
+
+// Stages of a run, reported as the category of the error a run failed with.
+const (
+	runStageDatabase = "database"
+	runStageSource   = "source"
+	runStageStore    = "store"
+	runStageReport   = "report"
+)
+
+// runStatus is the outcome of a run as written to the status file.
+type runStatus struct {
+	Success bool `json:"success"`
+	Added   int  `json:"added"`
+	Removed int  `json:"removed"`
+	// Version is the version of the list as stated by the source, omitted if unknown
+	Version string    `json:"version,omitempty"`
+	At      time.Time `json:"at"`
+	Error   string    `json:"error,omitempty"`
+	// ErrorCategory is the stage a failed run failed in, one of the runStage* constants.
+	// Added then holds the TLDs which were stored before, if any.
+	ErrorCategory string `json:"error_category,omitempty"`
+
+	// total and fetchDuration are only exported as metrics
+	total         int
+	fetchDuration time.Duration
+
+	// stage is the stage the run is currently in
+	stage string
+}
+
+// writeStatusFile atomically replaces the file at path by st.
+func writeStatusFile(path string, st runStatus) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("failed to JSON-encode status: %w", err)
+	}
+
+	if err := writeFileAtomic(path, append(b, '\n'), statusFilePerm); err != nil {
+		return fmt.Errorf("failed to write status file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunStatusFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cfg := newTestConfig(fixturePath, filepath.Join(dir, "db.sqlite"))
+	cfg.statusFile = filepath.Join(dir, "status.json")
+
+	readStatus := func(t *testing.T) runStatus {
+		t.Helper()
+
+		b, err := os.ReadFile(cfg.statusFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var st runStatus
+		if err := json.Unmarshal(b, &st); err != nil {
+			t.Fatalf("failed to decode status %q: %v", b, err)
+		}
+		return st
+	}
+
+	runToString(t, cfg)
+	if st := readStatus(t); !st.Success || st.Added != 42 || st.Removed != 0 || st.Version != "2025061000" || st.At.IsZero() {
+		t.Fatalf("unexpected status %+v", st)
+	}
+
+	cfg.source = filepath.Join(dir, "missing.txt")
+	if err := run(t.Context(), newTestLogger(), io.Discard, cfg); err == nil {
+		t.Fatal("run succeeded with a missing source")
+	}
+	if st := readStatus(t); st.Success || st.Error == "" || st.ErrorCategory != runStageSource {
+		t.Fatalf("unexpected status %+v", st)
+	}
+
+	// Changes stored before a later stage failed are recorded
+	cfg.source = fixturePath
+	cfg.sqliteFile = filepath.Join(dir, "fresh.sqlite")
+	cfg.outputs = []outputTarget{{path: filepath.Join(dir, "missing", "out.json")}}
+	if err := run(t.Context(), newTestLogger(), io.Discard, cfg); err == nil {
+		t.Fatal("run succeeded with an unwritable output")
+	}
+	if st := readStatus(t); st.Success || st.Added != 42 || st.ErrorCategory != runStageReport {
+		t.Fatalf("unexpected status %+v", st)
+	}
+	cfg.sqliteFile = filepath.Join(dir, "db.sqlite")
+	if err := os.Remove(filepath.Join(dir, "fresh.sqlite")); err != nil {
+		t.Fatal(err)
+	}
+
+	// No temporary files must be left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(entries), 2; got != want {
+		t.Fatalf("got %d files in %q, want %d", got, dir, want)
+	}
+}
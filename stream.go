@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	// streamBufferSize is the number of parsed TLDs which may be queued up for insertion.
+	streamBufferSize = 1024
+	// streamBatchSize is the number of TLDs inserted per transaction.
+	streamBatchSize = 1000
+)
+
+// parsedTLD is a TLD along with its original casing, see scanTLDs.
+type parsedTLD struct {
+	tld  tld
+	orig string
+}
+
+// streamWithDB loads the TLDs from cfg.source and stores them in db while they're being parsed.
+// Unlike loadTLDs followed by syncWithDB, only the added TLDs are kept in memory.
+// As the stored TLDs aren't loaded either, removed TLDs aren't reported.
+// Batches are committed as they go, hence on error the result still holds the TLDs added by the committed ones.
+// db is owned by the caller and is not closed.
+func streamWithDB(
+	ctx context.Context,
+	l *slog.Logger,
+	db *sql.DB,
+	cfg config,
+) (result, error) {
+	initialized, err := ensureSchema(ctx, l, db)
+	if err != nil {
+		return result{}, err
+	}
+
+	// Cancel the parser in case storing fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	parseOpts := cfg.parse
+	parseOpts.streaming = true
+	parseOpts.warnings = new(parseWarnings)
+	var version string
+	parseOpts.version = &version
+
+	next := make(chan parsedTLD, streamBufferSize)
+	parseErr := make(chan error, 1)
+	go func() {
+		defer close(next)
+		parseErr <- streamTLDs(ctx, requestTimeout, l, cfg.source, cfg.fetch, parseOpts, func(t tld, orig string) error {
+			select {
+			case next <- parsedTLD{tld: t, orig: orig}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err() //nolint:wrapcheck // Context errors are fine as-is
+			}
+		})
+	}()
+
+	res := result{
+		InitialImport: initialized,
+		Added:         make([]tld, 0),
+		Removed:       make([]tld, 0),
+		display:       make(map[tld]string),
+	}
+	seenAt := time.Now().UTC().Format(time.RFC3339)
+	for {
+		committed := len(res.Added)
+		n, err := insertBatch(ctx, l, db, next, storeOptions{ordinals: hasOrdinals(cfg), source: cfg.source, parse: cfg.parse}, seenAt, &res)
+		if err != nil {
+			cancel()
+			// Wait for the parser to stop, its error is merely a consequence of ours
+			<-parseErr
+			// The failed batch was rolled back
+			res.Added = res.Added[:committed]
+			return res, err
+		}
+		if n == 0 {
+			break
+		}
+		res.total += n
+	}
+
+	if err := <-parseErr; err != nil {
+		return res, err
+	}
+	res.version = version
+	if !parseOpts.warnings.empty() {
+		res.Warnings = parseOpts.warnings
+	}
+
+	return res, nil
+}
+
+// insertBatch inserts up to streamBatchSize TLDs received from next in a single transaction and records the added ones in res.
+// Ordinals continue from res.total, the last seen timestamp of every TLD received is set to seenAt.
+// It returns the number of TLDs received, zero once next is closed.
+func insertBatch(
+	ctx context.Context,
+	l *slog.Logger,
+	db *sql.DB,
+	next <-chan parsedTLD,
+	opts storeOptions,
+	seenAt string,
+	res *result,
+) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			l.ErrorContext(ctx, fmt.Errorf("failed to roll back transaction: %w", err).Error())
+		}
+	}()
+
+	stmt, err := tx.PrepareContext(ctx, sqliteRestoreInsertStmt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare insert statement: %w", err)
+	}
+	defer func() {
+		if err := stmt.Close(); err != nil {
+			l.ErrorContext(ctx, fmt.Errorf("failed to close insert statement: %w", err).Error())
+		}
+	}()
+
+	seenStmt, err := tx.PrepareContext(ctx, sqliteLastSeenSetStmt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare last seen statement: %w", err)
+	}
+	defer func() {
+		if err := seenStmt.Close(); err != nil {
+			l.ErrorContext(ctx, fmt.Errorf("failed to close last seen statement: %w", err).Error())
+		}
+	}()
+
+	var n int
+	for ; n < streamBatchSize; n++ {
+		p, ok := <-next
+		if !ok {
+			break
+		}
+
+		kind, overridden := opts.kind(p.tld)
+		r, err := stmt.ExecContext(ctx, p.tld, opts.ordinal(res.total+n), opts.sourceValue(), kind, overridden)
+		if err != nil {
+			return 0, fmt.Errorf("failed to insert %q: %w", p.tld, err)
+		}
+		if _, err := seenStmt.ExecContext(ctx, seenAt, p.tld); err != nil {
+			return 0, fmt.Errorf("failed to update last seen of %q: %w", p.tld, err)
+		}
+		affected, err := r.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to get number of inserted rows: %w", err)
+		}
+		if affected == 0 {
+			continue
+		}
+
+		res.Added = append(res.Added, p.tld)
+		if p.orig != "" {
+			res.display[p.tld] = p.orig
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return n, nil
+}
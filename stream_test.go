@@ -0,0 +1,142 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/metrics"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunStream(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestConfig(fixturePath, filepath.Join(t.TempDir(), "db.sqlite"))
+	cfg.parse.preserveCase = true
+	want := runToJSONConfig(t, cfg)
+
+	cfg.sqliteFile = filepath.Join(t.TempDir(), "db.sqlite")
+	cfg.stream = true
+	added := runToJSONConfig(t, cfg)
+	if got := slices.Sorted(slices.Values(added)); !slices.Equal(got, slices.Sorted(slices.Values(want))) {
+		t.Fatalf("got %v when streaming, want %v", got, want)
+	}
+
+	if added := runToJSONConfig(t, cfg); len(added) != 0 {
+		t.Fatalf("got %d added TLDs on second run, want none: %v", len(added), added)
+	}
+}
+
+func TestRunStreamVerifyChecksum(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestConfig(fixturePath, filepath.Join(t.TempDir(), "db.sqlite"))
+	cfg.stream = true
+	cfg.fetch.verifyChecksum = true
+	if err := run(t.Context(), newTestLogger(), io.Discard, cfg); !errors.Is(err, errChecksumStream) {
+		t.Fatalf("got error %v, want %v", err, errChecksumStream)
+	}
+}
+
+// benchmarkSourceSize is the number of TLDs in the source generated for benchmarks.
+const benchmarkSourceSize = 100_000
+
+func writeBenchmarkSource(b *testing.B) string {
+	b.Helper()
+
+	path := filepath.Join(b.TempDir(), "tlds.txt")
+	f, err := os.Create(path) //nolint:gosec // The path is a temporary file
+	if err != nil {
+		b.Fatal(err)
+	}
+	w := bufio.NewWriter(f)
+	for i := range benchmarkSourceSize {
+		if _, err := fmt.Fprintf(w, "TLD%07d\n", i); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		b.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	return path
+}
+
+// measurePeakHeap samples the heap size until the returned function is called, which reports the peak.
+func measurePeakHeap() func() uint64 {
+	const interval = time.Millisecond
+
+	samples := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	done := make(chan struct{})
+
+	var (
+		wg   sync.WaitGroup
+		peak uint64
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			metrics.Read(samples)
+			peak = max(peak, samples[0].Value.Uint64())
+
+			select {
+			case <-done:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+
+	return func() uint64 {
+		close(done)
+		wg.Wait()
+		return peak
+	}
+}
+
+func benchmarkRun(b *testing.B, stream bool) {
+	b.Helper()
+
+	cfg := newTestConfig(writeBenchmarkSource(b), filepath.Join(b.TempDir(), "db.sqlite"))
+	cfg.stream = true
+	// Import the TLDs upfront so that the memory needed to report them isn't measured
+	if err := run(b.Context(), newTestLogger(), io.Discard, cfg); err != nil {
+		b.Fatalf("initial run failed: %v", err)
+	}
+	cfg.stream = stream
+
+	b.ReportAllocs()
+
+	var peak uint64
+	for b.Loop() {
+		runtime.GC()
+		stop := measurePeakHeap()
+		if err := run(b.Context(), newTestLogger(), io.Discard, cfg); err != nil {
+			b.Fatalf("run failed: %v", err)
+		}
+		peak = max(peak, stop())
+	}
+	b.ReportMetric(float64(peak), "peak-heap-B")
+}
+
+func BenchmarkRunInMemory(b *testing.B) {
+	benchmarkRun(b, false)
+}
+
+func BenchmarkRunStream(b *testing.B) {
+	benchmarkRun(b, true)
+}
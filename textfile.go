@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const metricsTextfilePerm = 0o644
+
+//nolint:gochecknoglobals // A replacer can't be a constant
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeMetricsTextfile atomically replaces the file at path by the metrics of st
+// in the Prometheus text format, as read by the textfile collector of node_exporter.
+// The TLD metrics are omitted if the run failed as they're unknown then,
+// except for the number of TLDs which were added before the failure.
+func writeMetricsTextfile(path string, st runStatus, runLabel string) error {
+	var pairs []string
+	if runLabel != "" {
+		pairs = append(pairs, promLabel("run_label", runLabel))
+	}
+
+	var b strings.Builder
+	gauge := func(name, help string, v any, extra ...string) {
+		var labels string
+		if all := append(slices.Clone(pairs), extra...); len(all) > 0 {
+			labels = "{" + strings.Join(all, ",") + "}"
+		}
+		//nolint:errcheck // Writing to a strings.Builder never fails
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s%s %v\n", name, help, name, name, labels, v)
+	}
+
+	success := 0
+	if st.Success {
+		success = 1
+	}
+	gauge("tldwatch_last_run_success", "Whether the last run succeeded.", success)
+	gauge("tldwatch_last_run_timestamp_seconds", "Time of the last run.", st.At.Unix())
+	if st.Success {
+		gauge("tldwatch_tlds_total", "Number of TLDs in the source.", st.total)
+		gauge("tldwatch_tlds_added", "Number of TLDs added by the last run.", st.Added)
+		gauge("tldwatch_tlds_removed", "Number of TLDs removed by the last run.", st.Removed)
+		gauge("tldwatch_fetch_duration_seconds", "Time taken to fetch the source.", st.fetchDuration.Seconds())
+	} else {
+		gauge("tldwatch_tlds_added", "Number of TLDs added by the last run.", st.Added)
+		if st.ErrorCategory != "" {
+			gauge("tldwatch_last_run_error", "Stage the last run failed in.", 1, promLabel("category", st.ErrorCategory))
+		}
+	}
+
+	if err := writeFileAtomic(path, []byte(b.String()), metricsTextfilePerm); err != nil {
+		return fmt.Errorf("failed to write metrics textfile: %w", err)
+	}
+
+	return nil
+}
+
+func promLabel(name, value string) string {
+	return name + `="` + promLabelEscaper.Replace(value) + `"`
+}
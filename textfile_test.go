@@ -0,0 +1,64 @@
+package main //nolint:testpackage // package main can't be imported from an external test package
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetricsTextfile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "tldwatch.prom")
+
+	st := runStatus{
+		Success:       true,
+		Added:         2,
+		At:            time.Unix(1750000000, 0),
+		total:         42,
+		fetchDuration: 1500 * time.Millisecond,
+	}
+	if err := writeMetricsTextfile(path, st, `prod "eu"`); err != nil {
+		t.Fatalf("failed to write metrics: %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# TYPE tldwatch_tlds_total gauge\n",
+		`tldwatch_tlds_total{run_label="prod \"eu\""} 42` + "\n",
+		`tldwatch_tlds_added{run_label="prod \"eu\""} 2` + "\n",
+		`tldwatch_fetch_duration_seconds{run_label="prod \"eu\""} 1.5` + "\n",
+		`tldwatch_last_run_timestamp_seconds{run_label="prod \"eu\""} 1750000000` + "\n",
+		`tldwatch_last_run_success{run_label="prod \"eu\""} 1` + "\n",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("metrics %q don't contain %q", b, want)
+		}
+	}
+
+	st.Success, st.ErrorCategory = false, runStageStore
+	if err := writeMetricsTextfile(path, st, ""); err != nil {
+		t.Fatalf("failed to write metrics: %v", err)
+	}
+	if b, err = os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	got := string(b)
+	if !strings.Contains(got, "tldwatch_last_run_success 0\n") || strings.Contains(got, "tldwatch_tlds_total") {
+		t.Errorf("unexpected metrics for a failed run %q", got)
+	}
+	// What was accomplished before the failure is still reported
+	for _, want := range []string{
+		"tldwatch_tlds_added 2\n",
+		`tldwatch_last_run_error{category="store"} 1` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("metrics of a failed run %q don't contain %q", got, want)
+		}
+	}
+}